@@ -0,0 +1,48 @@
+package revoke
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+)
+
+// BatchResult is the outcome of checking one certificate in a batch passed to CheckBatch.
+type BatchResult struct {
+	Result *CheckResult
+	Err    error
+}
+
+// CheckBatch checks each certificate like CheckContext, running at most concurrency checks at the same time, and
+// returns the results in the same order as the certificates. A concurrency less than 1 checks every certificate at the
+// same time. Certificates sharing a CRL fetch it once, as concurrent fetches of the same CRL share a single request and
+// later checks use the cache.
+func CheckBatch(ctx context.Context, certs []*x509.Certificate, concurrency int) []BatchResult {
+	if concurrency < 1 || concurrency > len(certs) {
+		concurrency = len(certs)
+	}
+
+	var (
+		results = make([]BatchResult, len(certs))
+		sem     = make(chan struct{}, concurrency)
+		wg      sync.WaitGroup
+	)
+
+	for i, cert := range certs {
+		sem <- struct{}{}
+
+		wg.Add(1)
+
+		go func(i int, cert *x509.Certificate) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[i].Result, results[i].Err = CheckContext(ctx, cert)
+		}(i, cert)
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,552 @@
+package revoke
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	// CacheOCSP determines whether OCSP responses are cached until their NextUpdate time. Disabling it causes every
+	// check to query the OCSP responder.
+	CacheOCSP = true
+
+	ocspCache = map[string]*ocsp.Response{}
+
+	ocspLock = new(sync.Mutex)
+
+	ocspRequests = map[string][]byte{}
+
+	ocspRequestLock = new(sync.RWMutex)
+
+	// MaxCRLCacheEntries is the maximum number of CRLs kept in the cache. When it's exceeded the least recently used
+	// CRL is evicted, and is fetched again the next time it's needed. Zero means the cache is unbounded.
+	MaxCRLCacheEntries = 0
+
+	// DefaultCRLTTL is how long a CRL without a next update time is considered fresh, after which it's fetched again.
+	// Without it such a CRL would be fetched for every check. Zero or less disables it.
+	DefaultCRLTTL = time.Hour
+
+	// crlUsed and crlStates are keyed like CRLSet and, like it, are only accessed while holding crlLock.
+	crlUsed = map[string]*atomic.Int64{}
+	crlTick atomic.Int64
+
+	crlStates = map[string]*crlState{}
+
+	crlFetches   = map[string]*crlFetch{}
+	crlFetchLock = new(sync.Mutex)
+	crlFetching  sync.WaitGroup
+
+	refreshers     sync.WaitGroup
+	refresherStops []context.CancelFunc
+	refresherLock  = new(sync.Mutex)
+
+	errCRLNotModified = errors.New("CRL not modified")
+)
+
+// crlFetch is a fetch of a CRL shared by the callers waiting for it.
+type crlFetch struct {
+	done    chan struct{}
+	val     any
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// fetchCRLShared calls fetch for the CRL at the URL, sharing a single call between concurrent callers with the same
+// issuer so a CRL is only verified with the issuer each caller expects. The shared fetch runs on a context which isn't
+// cancelled with the caller's, bounded by CheckTimeout, so one caller giving up doesn't fail the fetch for the others.
+// Each caller stops waiting when its own context is done, and the fetch is cancelled once every caller has stopped.
+func fetchCRLShared(ctx context.Context, url string, issuer *x509.Certificate, fetch func(ctx context.Context) (any, error)) (any, error) {
+	key := crlFetchKey(url, issuer)
+
+	crlFetchLock.Lock()
+
+	f, ok := crlFetches[key]
+	if !ok {
+		var (
+			fctx   context.Context
+			cancel context.CancelFunc
+		)
+
+		if CheckTimeout > 0 {
+			fctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), CheckTimeout)
+		} else {
+			fctx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		}
+
+		f = &crlFetch{done: make(chan struct{}), cancel: cancel}
+		crlFetches[key] = f
+
+		crlFetching.Add(1)
+
+		go func() {
+			defer crlFetching.Done()
+
+			val, err := fetch(fctx)
+
+			crlFetchLock.Lock()
+			f.val, f.err = val, err
+
+			if crlFetches[key] == f {
+				delete(crlFetches, key)
+			}
+			crlFetchLock.Unlock()
+
+			cancel()
+			close(f.done)
+		}()
+	}
+
+	f.waiters++
+
+	crlFetchLock.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		crlFetchLock.Lock()
+		if f.waiters--; f.waiters == 0 {
+			f.cancel()
+
+			// A later caller starts a new fetch rather than joining the cancelled one.
+			if crlFetches[key] == f {
+				delete(crlFetches, key)
+			}
+		}
+		crlFetchLock.Unlock()
+
+		return nil, ctx.Err()
+	}
+}
+
+// crlFetchKey returns the key which concurrent fetches of the CRL at the URL verified with the issuer share.
+func crlFetchKey(url string, issuer *x509.Certificate) string {
+	if issuer == nil {
+		return url
+	}
+
+	sum := sha256.Sum256(issuer.Raw)
+
+	return url + " " + hex.EncodeToString(sum[:])
+}
+
+// crlState holds the HTTP validators of a fetched CRL, the time until which it's considered fresh after the server
+// confirmed it's unchanged despite its next update time having passed, and the issuer and time it was last loaded for
+// the background refresher.
+type crlState struct {
+	etag         string
+	lastModified string
+	freshUntil   time.Time
+	issuer       *x509.Certificate
+	loaded       time.Time
+}
+
+// StartRefresher starts refreshing fetched CRLs in the background every interval until the context is done, so checks
+// are served from the cache rather than waiting for an expired CRL to be fetched. A CRL is refreshed when it expires
+// within the next interval, unless it was loaded within the last interval. CRLs added with AddCRL or AddCRLFromFile
+// aren't refreshed. Refreshers are also stopped by Reset. An interval of zero or less starts nothing.
+func StartRefresher(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		if logger != nil {
+			logger.Warn("CRL refresher not started", "interval", interval)
+		}
+
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	refresherLock.Lock()
+	refresherStops = append(refresherStops, cancel)
+	refresherLock.Unlock()
+
+	refreshers.Add(1)
+
+	go func() {
+		defer refreshers.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshCRLs(ctx, interval)
+			}
+		}
+	}()
+}
+
+// crlDue returns true if the CRL for the URL with the update times should be refreshed by the background refresher
+// running every interval, along with the issuer to verify it with. The caller must hold crlLock for reading.
+func crlDue(url string, thisUpdate, nextUpdate time.Time, interval time.Duration) (issuer *x509.Certificate, due bool) {
+	meta, ok := crlStates[url]
+	if !ok {
+		return nil, false
+	}
+
+	current := now()
+
+	if current.Sub(meta.loaded) < interval {
+		return nil, false
+	}
+
+	nextUpdate = crlExpiry(url, thisUpdate, nextUpdate)
+
+	if meta.freshUntil.After(nextUpdate) {
+		nextUpdate = meta.freshUntil
+	}
+
+	return meta.issuer, nextUpdate.Sub(current) <= interval
+}
+
+// CRLMeta describes a cached CRL, for recording which revision of a CRL a decision was based on.
+type CRLMeta struct {
+	ThisUpdate time.Time
+	NextUpdate time.Time
+
+	// Number is the CRL number, or nil if the CRL doesn't have one.
+	Number *big.Int
+
+	// EntryCount is the number of revoked certificates listed in the CRL.
+	EntryCount int
+}
+
+// ocspCacheKey returns the key for an OCSP response, derived from the issuer's public key and the serial number of the
+// certificate.
+func ocspCacheKey(leaf, issuer *x509.Certificate) string {
+	sum := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+
+	return hex.EncodeToString(sum[:]) + ":" + leaf.SerialNumber.Text(16)
+}
+
+// ocspCacheGet returns the cached OCSP response for the certificate if one exists and is still current.
+func ocspCacheGet(leaf, issuer *x509.Certificate) *ocsp.Response {
+	key := ocspCacheKey(leaf, issuer)
+
+	ocspLock.Lock()
+	defer ocspLock.Unlock()
+
+	resp, ok := ocspCache[key]
+	if !ok {
+		return nil
+	}
+
+	if !now().Before(resp.NextUpdate) {
+		delete(ocspCache, key)
+
+		ocspStats.evictions.Add(1)
+		ocspStats.entries.Store(int64(len(ocspCache)))
+
+		return nil
+	}
+
+	return resp
+}
+
+// ocspCachePut caches the OCSP response for the certificate. Responses without a NextUpdate time aren't cached as
+// there's no way to know when they become stale.
+func ocspCachePut(leaf, issuer *x509.Certificate, resp *ocsp.Response) {
+	if resp.NextUpdate.IsZero() {
+		return
+	}
+
+	ocspLock.Lock()
+	ocspCache[ocspCacheKey(leaf, issuer)] = resp
+	ocspStats.entries.Store(int64(len(ocspCache)))
+	ocspLock.Unlock()
+}
+
+// maxOCSPRequests is the number of OCSP requests kept for reuse, after which they're all discarded.
+const maxOCSPRequests = 1024
+
+// ocspRequestCacheGet returns the OCSP request previously created for the certificate using the hash algorithm, if any.
+func ocspRequestCacheGet(leaf, issuer *x509.Certificate, hash crypto.Hash) []byte {
+	ocspRequestLock.RLock()
+	defer ocspRequestLock.RUnlock()
+
+	return ocspRequests[ocspRequestKey(leaf, issuer, hash)]
+}
+
+// ocspRequestCachePut keeps the OCSP request created for the certificate using the hash algorithm for reuse.
+func ocspRequestCachePut(leaf, issuer *x509.Certificate, hash crypto.Hash, req []byte) {
+	ocspRequestLock.Lock()
+	defer ocspRequestLock.Unlock()
+
+	if len(ocspRequests) >= maxOCSPRequests {
+		ocspRequests = map[string][]byte{}
+	}
+
+	ocspRequests[ocspRequestKey(leaf, issuer, hash)] = req
+}
+
+// ocspRequestKey returns the key for an OCSP request, which includes the hash algorithm so changing it with
+// SetOCSPHash doesn't reuse requests created with the previous one.
+func ocspRequestKey(leaf, issuer *x509.Certificate, hash crypto.Hash) string {
+	return ocspCacheKey(leaf, issuer) + ":" + hash.String()
+}
+
+// Reset stops every refresher started with StartRefresher and cancels every CRL fetch in progress, waiting for them to
+// finish, then clears the CRL, OCSP, and issuer certificate caches. It's intended for shutting down or for dropping all cached state, and
+// the package can continue to be used afterwards.
+func Reset() {
+	refresherLock.Lock()
+	for _, stop := range refresherStops {
+		stop()
+	}
+	refresherStops = nil
+	refresherLock.Unlock()
+
+	refreshers.Wait()
+
+	crlFetchLock.Lock()
+	for _, f := range crlFetches {
+		f.cancel()
+	}
+	crlFetchLock.Unlock()
+
+	crlFetching.Wait()
+
+	ClearCRLCache()
+	ClearIssuerCache()
+
+	ocspLock.Lock()
+	ocspCache = map[string]*ocsp.Response{}
+	ocspStats.entries.Store(0)
+	ocspLock.Unlock()
+
+	ocspRequestLock.Lock()
+	ocspRequests = map[string][]byte{}
+	ocspRequestLock.Unlock()
+}
+
+// ClearCRLCache removes every CRL from the cache, forcing each to be fetched again the next time it's needed.
+func ClearCRLCache() {
+	crlLock.Lock()
+	defer crlLock.Unlock()
+
+	for url := range CRLSet {
+		delete(CRLSet, url)
+	}
+
+	for url := range crlUsed {
+		delete(crlUsed, url)
+	}
+
+	for url := range crlStates {
+		delete(crlStates, url)
+	}
+
+	crlStats.entries.Store(0)
+}
+
+// LoadCRLBundle parses every X509 CRL PEM block read from r, such as a file concatenating the CRLs of several CAs,
+// and adds each to the cache keyed by its issuer rather than a URI. A certificate without a usable CRL distribution
+// point is checked against the CRL loaded for its issuer, which allows offline checking with CRLs shipped as a bundle.
+// Like AddCRL, the CRLs are trusted as given and their signatures aren't checked. A bundled CRL is never fetched again,
+// so once it expires checks against it fail with ErrBundleCRLExpired until a newer bundle is loaded. It returns the
+// number of CRLs loaded, and stops at the first block which can't be parsed.
+func LoadCRLBundle(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		block *pem.Block
+		n     int
+	)
+
+	for {
+		if block, data = pem.Decode(data); block == nil {
+			return n, nil
+		}
+
+		if block.Type != "X509 CRL" {
+			continue
+		}
+
+		crl, err := parseCRL(block.Bytes)
+		if err != nil {
+			return n, err
+		}
+
+		AddCRL(bundleCRLKey(crlIssuer(crl)), crl)
+
+		n++
+	}
+}
+
+// bundleCRLPrefix prefixes the keys CRLs loaded with LoadCRLBundle are cached under.
+const bundleCRLPrefix = "crl-bundle:"
+
+// bundleCRLKey returns the key a CRL loaded with LoadCRLBundle is cached under for the DER encoded issuer name. The
+// encoded name is used as the parsed name leaves out the attributes it doesn't have a field for.
+func bundleCRLKey(rawIssuer []byte) string {
+	sum := sha256.Sum256(rawIssuer)
+
+	return bundleCRLPrefix + hex.EncodeToString(sum[:])
+}
+
+// isBundleCRLKey returns true if the key is one a CRL loaded with LoadCRLBundle is cached under rather than a URL.
+func isBundleCRLKey(key string) bool {
+	return strings.HasPrefix(key, bundleCRLPrefix)
+}
+
+// bundleCRL returns the key of the CRL loaded with LoadCRLBundle for the issuer of the certificate, if there is one.
+func bundleCRL(cert *x509.Certificate) (key string, ok bool) {
+	key = bundleCRLKey(cert.RawIssuer)
+
+	crlLock.RLock()
+	_, ok = CRLSet[key]
+	crlLock.RUnlock()
+
+	return key, ok
+}
+
+// crlFresh returns true if the cached CRL for the URL with the update times can be used without revalidating it. The
+// caller must hold crlLock for reading.
+func crlFresh(url string, thisUpdate, nextUpdate time.Time) bool {
+	current := now()
+
+	if current.Before(crlExpiry(url, thisUpdate, nextUpdate)) {
+		return true
+	}
+
+	meta, ok := crlStates[url]
+
+	return ok && current.Before(meta.freshUntil)
+}
+
+// crlExpiry returns the time the cached CRL for the URL with the update times expires. A CRL without a next update
+// time expires DefaultCRLTTL after it was loaded, or after its this update time if it was added to the cache directly.
+// The caller must hold crlLock for reading.
+func crlExpiry(url string, thisUpdate, nextUpdate time.Time) time.Time {
+	if !nextUpdate.IsZero() || DefaultCRLTTL <= 0 {
+		return nextUpdate
+	}
+
+	if meta, ok := crlStates[url]; ok && meta.loaded.After(thisUpdate) {
+		thisUpdate = meta.loaded
+	}
+
+	return thisUpdate.Add(DefaultCRLTTL)
+}
+
+// crlValidated records the HTTP validators from the response header of the CRL fetched from the URL, once the CRL has
+// been accepted, for conditional requests when it's refetched. The caller must hold crlLock for writing.
+func crlValidated(url string, header http.Header) {
+	meta := crlStateFor(url)
+	meta.etag, meta.lastModified = header.Get("ETag"), header.Get("Last-Modified")
+}
+
+// crlLoaded records that the CRL for the URL was loaded from its distribution point, and the issuer it was verified
+// with if any. The caller must hold crlLock for writing.
+func crlLoaded(url string, issuer *x509.Certificate) {
+	meta := crlStateFor(url)
+	meta.loaded = now()
+
+	if issuer != nil {
+		meta.issuer = issuer
+	}
+}
+
+// crlStateFor returns the state of the CRL fetched from the URL, creating it if necessary. The caller must hold
+// crlLock for writing.
+func crlStateFor(url string) *crlState {
+	meta, ok := crlStates[url]
+	if !ok {
+		meta = &crlState{}
+		crlStates[url] = meta
+	}
+
+	return meta
+}
+
+// crlRevalidated extends the freshness of the cached CRL for the URL after the server confirmed it's unchanged. It's
+// extended by the interval between the CRL's this update and next update times, or DefaultCRLTTL if that's unknown.
+func crlRevalidated(url string, thisUpdate, nextUpdate time.Time) {
+	ttl := nextUpdate.Sub(thisUpdate)
+	if ttl <= 0 {
+		ttl = DefaultCRLTTL
+	}
+
+	crlLock.Lock()
+	defer crlLock.Unlock()
+
+	if meta, ok := crlStates[url]; ok {
+		meta.freshUntil = now().Add(ttl)
+		meta.loaded = now()
+	}
+}
+
+// crlTouch records a use of the cached CRL for the URL. The caller must hold crlLock for reading.
+func crlTouch(url string) {
+	if used, ok := crlUsed[url]; ok {
+		used.Store(crlTick.Add(1))
+	}
+}
+
+// crlStored records that the CRL for the URL was stored in the cache, then evicts the least recently used CRLs while
+// the cache holds more than MaxCRLCacheEntries. The caller must hold crlLock for writing.
+func crlStored(url string) {
+	used, ok := crlUsed[url]
+	if !ok {
+		used = new(atomic.Int64)
+		crlUsed[url] = used
+	}
+
+	used.Store(crlTick.Add(1))
+
+	defer func() { crlStats.entries.Store(int64(len(CRLSet))) }()
+
+	if MaxCRLCacheEntries <= 0 {
+		return
+	}
+
+	for len(CRLSet) > MaxCRLCacheEntries {
+		var (
+			oldest     string
+			oldestTick int64 = -1
+		)
+
+		for u := range CRLSet {
+			var tick int64
+
+			if used, ok := crlUsed[u]; ok {
+				tick = used.Load()
+			}
+
+			if u != url && (oldestTick == -1 || tick < oldestTick) {
+				oldest, oldestTick = u, tick
+			}
+		}
+
+		if oldestTick == -1 {
+			return
+		}
+
+		delete(CRLSet, oldest)
+		delete(crlUsed, oldest)
+		delete(crlStates, oldest)
+
+		crlStats.evictions.Add(1)
+	}
+}
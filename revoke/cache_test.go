@@ -0,0 +1,293 @@
+package revoke
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetCRLWaiterOutlivesCancelledCaller(t *testing.T) {
+	resetForTest(t)
+
+	ca, caKey := newTestCA(t, "ca")
+	crl := newTestCRL(t, ca, caKey, 1)
+
+	var once sync.Once
+
+	requested, release := make(chan struct{}), make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(requested) })
+		<-release
+		_, _ = w.Write(crl)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	first := make(chan error, 1)
+
+	go func() {
+		_, _, err := getCRL(ctx, ca, srv.URL)
+		first <- err
+	}()
+
+	<-requested
+
+	second := make(chan error, 1)
+
+	go func() {
+		_, _, err := getCRL(context.Background(), ca, srv.URL)
+		second <- err
+	}()
+
+	cancel()
+
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the cancelled caller to fail with its context's error, got %v", err)
+	}
+
+	close(release)
+
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("expected the waiting caller to get the CRL, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting caller didn't return")
+	}
+}
+
+func TestRejectedCRLKeepsValidators(t *testing.T) {
+	resetForTest(t)
+
+	ca, caKey := newTestCA(t, "ca")
+
+	// An expired CRL so each check refetches it conditionally.
+	expired := func(number int64) []byte {
+		der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:     big.NewInt(number),
+			ThisUpdate: time.Now().Add(-2 * time.Hour),
+			NextUpdate: time.Now().Add(-time.Hour),
+		}, ca, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return der
+	}
+
+	var (
+		mu          sync.Mutex
+		number      int64 = 2
+		ifNoneMatch []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		ifNoneMatch = append(ifNoneMatch, r.Header.Get("If-None-Match"))
+
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, number))
+		_, _ = w.Write(expired(number))
+	}))
+	defer srv.Close()
+
+	if _, _, err := getCRL(context.Background(), ca, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	number = 1
+	mu.Unlock()
+
+	if _, _, err := getCRL(context.Background(), ca, srv.URL); !errors.Is(err, ErrCRLNumberRegressed) {
+		t.Fatalf("expected the older CRL to be rejected, got %v", err)
+	}
+
+	_, _, _ = getCRL(context.Background(), ca, srv.URL)
+
+	if got := ifNoneMatch[2]; got != `"2"` {
+		t.Fatalf("expected the validator of the accepted CRL, got %q", got)
+	}
+}
+
+func TestStartRefresherIgnoresNonPositiveInterval(t *testing.T) {
+	resetForTest(t)
+
+	StartRefresher(context.Background(), 0)
+	StartRefresher(context.Background(), -time.Second)
+
+	refresherLock.Lock()
+	n := len(refresherStops)
+	refresherLock.Unlock()
+
+	if n != 0 {
+		t.Fatalf("expected no refreshers to be started, got %d", n)
+	}
+}
+
+// TestCRLCacheConcurrentLookups checks many concurrent lookups of CRLs, and is intended to be run with -race.
+func TestCRLCacheConcurrentLookups(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expired  bool
+		noUpdate bool
+		urls     int
+	}{
+		{name: "Fresh", urls: 1},
+		{name: "Expired", expired: true, urls: 1},
+		{name: "DifferentURLs", urls: 8},
+		{name: "ExpiredDifferentURLs", expired: true, urls: 8},
+		{name: "NoNextUpdateDifferentURLs", noUpdate: true, urls: 8},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetForTest(t)
+
+			ca, caKey := newTestCA(t, "ca")
+			crl := newTestCRL(t, ca, caKey, 1, 7)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(crl)
+			}))
+			defer srv.Close()
+
+			if tc.expired {
+				// Every lookup finds the CRL past its next update time and refetches it.
+				SetClock(func() time.Time { return time.Now().Add(2 * time.Hour) })
+				t.Cleanup(func() { SetClock(nil) })
+			}
+
+			if tc.noUpdate {
+				// Cached CRLs without a next update time expire DefaultCRLTTL after their this update time.
+				for i := 0; i < tc.urls; i++ {
+					parsed, err := x509.ParseRevocationList(crl)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					parsed.ThisUpdate, parsed.NextUpdate = time.Now().Add(-2*DefaultCRLTTL), time.Time{}
+
+					AddCRL(fmt.Sprintf("%s/%d.crl", srv.URL, i), parsed)
+				}
+			}
+
+			leaf := newTestLeaf(t, ca, caKey, 7, nil)
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 64; i++ {
+				wg.Add(1)
+
+				go func(url string) {
+					defer wg.Done()
+
+					for j := 0; j < 20; j++ {
+						revoked, _, err := certIsRevokedCRL(context.Background(), leaf, ca, url, &CheckResult{})
+						if err != nil || !revoked {
+							t.Errorf("expected the certificate to be revoked, got %t, %v", revoked, err)
+
+							return
+						}
+					}
+				}(fmt.Sprintf("%s/%d.crl", srv.URL, i%tc.urls))
+			}
+
+			wg.Wait()
+
+			if stats := Stats(); !tc.expired && stats.CRL.Hits == 0 {
+				t.Fatalf("expected lookups to be served from the cache, got %+v", stats.CRL)
+			}
+		})
+	}
+}
+
+func TestBundleCRLExpiredIsNotFetched(t *testing.T) {
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+
+	resetForTest(t)
+
+	ca, caKey := newTestCA(t, "ca")
+
+	if _, err := LoadCRLBundle(bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: newTestCRL(t, ca, caKey, 1)}))); err != nil {
+		t.Fatal(err)
+	}
+
+	SetClock(func() time.Time { return time.Now().Add(2 * time.Hour) })
+	t.Cleanup(func() { SetClock(nil) })
+
+	SetHooks(Hooks{OnCRLFetch: func(uri string, _ time.Duration, _ error) {
+		t.Errorf("expected the bundled CRL not to be fetched, got a fetch of %s", uri)
+	}})
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.NotAfter = time.Now().Add(3 * time.Hour)
+	})
+
+	if _, err := revCheck(context.Background(), leaf, ca); !errors.Is(err, ErrBundleCRLExpired) {
+		t.Fatalf("expected ErrBundleCRLExpired, got %v", err)
+	}
+}
+
+// TestBundleCRLKeyedOnRawIssuer checks CRLs from issuers whose names only differ in an attribute pkix.Name has no field
+// for are kept apart.
+func TestBundleCRLKeyedOnRawIssuer(t *testing.T) {
+	resetForTest(t)
+
+	newCA := func(dc string) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject: pkix.Name{
+				CommonName: "ca",
+				ExtraNames: []pkix.AttributeTypeAndValue{{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, Value: dc}},
+			},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		}
+
+		return newTestCert(t, tpl, tpl, key, key), key
+	}
+
+	good, goodKey := newCA("good")
+	other, otherKey := newCA("other")
+
+	var bundle []byte
+
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: newTestCRL(t, good, goodKey, 1)})...)
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: newTestCRL(t, other, otherKey, 1, 2)})...)
+
+	if n, err := LoadCRLBundle(bytes.NewReader(bundle)); err != nil || n != 2 {
+		t.Fatalf("expected two CRLs to be loaded, got %d, %v", n, err)
+	}
+
+	result, err := revCheck(context.Background(), newTestLeaf(t, good, goodKey, 2, nil), good)
+	if err != nil || result.Revoked || !result.Determined {
+		t.Fatalf("expected the certificate not to be revoked per its own issuer's CRL, got %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,178 @@
+package revoke
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+)
+
+var (
+	// ChainConcurrency is the maximum number of certificates VerifyChain checks at the same time. A value less than 1
+	// checks every certificate in the chain at the same time.
+	ChainConcurrency = 0
+
+	// ChainFailFast determines whether VerifyChain returns as soon as any certificate in the chain is found to be
+	// revoked, or waits for every certificate to be checked and gathers all of the results.
+	ChainFailFast = true
+)
+
+// VerifyChain concurrently checks each certificate in the chain for revocation, using the next certificate in the chain
+// as the issuer of each certificate when it is the issuer, and skips the final certificate when it's a self-signed
+// root. It returns the same values as VerifyCertificateError, where revoked is true if any certificate is revoked and ok
+// is true only if every checked certificate was successfully checked. When ChainFailFast is false the errors from every
+// certificate are joined.
+func VerifyChain(chain []*x509.Certificate) (revoked, ok bool, err error) {
+	return VerifyChainContext(context.Background(), chain)
+}
+
+// VerifyChainContext is like VerifyChain but uses the context for every request made while checking the chain, so a
+// single deadline is shared by every certificate in the chain. Checks still running when it returns early are
+// cancelled.
+func VerifyChainContext(ctx context.Context, chain []*x509.Certificate) (revoked, ok bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := verifyChain(chain, func(i int) (*CheckResult, error) {
+		return checkCertificate(ctx, chain[i], chainIssuer(chain, i))
+	})
+
+	return result.Revoked, result.Determined, err
+}
+
+// ChainRevoked checks each certificate in the chain for revocation in order, using the next certificate in the chain
+// as the issuer of each certificate instead of fetching it. It returns as soon as a certificate is found to be revoked,
+// and skips the final certificate when it's a self-signed root. The ok value is true only if every checked certificate
+// was successfully checked, and the errors from every certificate are joined.
+func ChainRevoked(chain []*x509.Certificate) (revoked, ok bool, err error) {
+	return ChainRevokedContext(context.Background(), chain)
+}
+
+// ChainRevokedContext is like ChainRevoked but uses the context for every request made while checking the chain.
+func ChainRevokedContext(ctx context.Context, chain []*x509.Certificate) (revoked, ok bool, err error) {
+	var errs []error
+
+	ok = true
+
+	for i, cert := range chain {
+		if i == len(chain)-1 && isSelfSigned(cert) {
+			break
+		}
+
+		result, err := checkCertificate(ctx, cert, chainIssuer(chain, i))
+		if result.Revoked {
+			return true, result.Determined, err
+		}
+
+		if !result.Determined {
+			ok = false
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return false, ok, errors.Join(errs...)
+}
+
+// verifyChain concurrently calls check for the index of each certificate in the chain, other than a final self-signed
+// root, and returns the result which decided the outcome for the chain as a whole.
+func verifyChain(chain []*x509.Certificate, check func(i int) (*CheckResult, error)) (result *CheckResult, err error) {
+	type indexed struct {
+		i      int
+		result *CheckResult
+		err    error
+	}
+
+	// A self-signed root is trusted as is, and has no issuer to publish its revocation status.
+	if len(chain) != 0 && isSelfSigned(chain[len(chain)-1]) {
+		chain = chain[:len(chain)-1]
+	}
+
+	n := ChainConcurrency
+	if n < 1 || n > len(chain) {
+		n = len(chain)
+	}
+
+	var (
+		failFast = ChainFailFast
+		ch       = make(chan indexed, len(chain))
+		sem      = make(chan struct{}, n)
+		stop     = make(chan struct{})
+	)
+
+	defer close(stop)
+
+	for i := range chain {
+		go func(i int) {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+
+			defer func() { <-sem }()
+
+			r := indexed{i: i}
+
+			r.result, r.err = check(i)
+
+			ch <- r
+		}(i)
+	}
+
+	var (
+		results = make([]*CheckResult, len(chain))
+		errs    []error
+	)
+
+	for range chain {
+		r := <-ch
+
+		if r.result.Revoked && failFast {
+			return r.result, r.err
+		}
+
+		results[r.i] = r.result
+
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+
+	err = errors.Join(errs...)
+
+	for _, result = range results {
+		if result.Revoked {
+			return result, err
+		}
+	}
+
+	for _, result = range results {
+		if !result.Determined {
+			return result, err
+		}
+	}
+
+	if len(results) == 0 {
+		return &CheckResult{Determined: true}, nil
+	}
+
+	return results[0], err
+}
+
+// chainIssuer returns the certificate which follows the certificate at index i in the chain if it's the issuer of
+// that certificate, and nil otherwise.
+func chainIssuer(chain []*x509.Certificate, i int) *x509.Certificate {
+	if i+1 >= len(chain) || !bytes.Equal(chain[i].RawIssuer, chain[i+1].RawSubject) {
+		return nil
+	}
+
+	return chain[i+1]
+}
+
+// isSelfSigned returns true if the certificate is issued and signed by itself.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
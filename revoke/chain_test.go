@@ -0,0 +1,158 @@
+package revoke
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestChain returns a leaf, intermediate, and root chain, with the leaf and intermediate pointing at the CRL
+// distribution points.
+func newTestChain(t testing.TB, leafCRL, intermediateCRL func(issuer *x509.Certificate, key *ecdsa.PrivateKey) string) []*x509.Certificate {
+	t.Helper()
+
+	root, rootKey := newTestCA(t, "root")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intermediate := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		CRLDistributionPoints: []string{intermediateCRL(root, rootKey)},
+	}, root, key, rootKey)
+
+	leaf := newTestLeaf(t, intermediate, key, 3, func(tpl *x509.Certificate) {
+		tpl.CRLDistributionPoints = []string{leafCRL(intermediate, key)}
+	})
+
+	return []*x509.Certificate{leaf, intermediate, root}
+}
+
+func TestVerifyChainFailFastCancelsRunningChecks(t *testing.T) {
+	// Cleared after the reset, which waits for the cancelled CRL fetch.
+	t.Cleanup(func() { SetHooks(Hooks{}) })
+
+	resetForTest(t)
+
+	cancelled := make(chan struct{})
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	var fast *httptest.Server
+
+	chain := newTestChain(t, func(issuer *x509.Certificate, key *ecdsa.PrivateKey) string {
+		fast = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(newTestCRL(t, issuer, key, 1, 3))
+		}))
+
+		return fast.URL
+	}, func(*x509.Certificate, *ecdsa.PrivateKey) string {
+		return slow.URL
+	})
+	defer fast.Close()
+
+	// The cancelled check finishes in the background, so wait for it before the test ends.
+	finished := make(chan struct{})
+
+	SetHooks(Hooks{OnResult: func(result *CheckResult, err error) {
+		if result.Certificate == chain[1] {
+			close(finished)
+		}
+	}})
+
+	revoked, _, _ := VerifyChainContext(context.Background(), chain)
+	if !revoked {
+		t.Fatal("expected the chain to be revoked")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the running intermediate check to be cancelled")
+	}
+
+	<-finished
+}
+
+// TestVerifyConnectionSkipsSelfSignedRoot checks the root, which has no revocation information, doesn't fail a verified
+// chain when revocation information is required.
+func TestVerifyConnectionSkipsSelfSignedRoot(t *testing.T) {
+	resetForTest(t)
+
+	HardFail, RequireRevocationInfo = true, true
+	t.Cleanup(func() { HardFail, RequireRevocationInfo = false, false })
+
+	crlServer := func(issuer *x509.Certificate, key *ecdsa.PrivateKey) string {
+		crl := newTestCRL(t, issuer, key, 1)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(crl)
+		}))
+		t.Cleanup(srv.Close)
+
+		return srv.URL
+	}
+
+	chain := newTestChain(t, crlServer, crlServer)
+
+	if err := VerifyConnection(tls.ConnectionState{PeerCertificates: chain[:2], VerifiedChains: [][]*x509.Certificate{chain}}); err != nil {
+		t.Fatalf("expected the verified chain to be accepted, got %v", err)
+	}
+
+	if revoked, ok, err := VerifyChain(chain); revoked || !ok || err != nil {
+		t.Fatalf("expected a good chain, got revoked %t, ok %t, err %v", revoked, ok, err)
+	}
+}
+
+// BenchmarkVerifyChain checks a chain whose CRLs are cached after the first iteration. Run it with -race to check
+// the concurrent checks of the chain.
+func BenchmarkVerifyChain(b *testing.B) {
+	b.Cleanup(Reset)
+
+	crlServer := func(issuer *x509.Certificate, key *ecdsa.PrivateKey) string {
+		crl := newTestCRL(b, issuer, key, 1)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(crl)
+		}))
+		b.Cleanup(srv.Close)
+
+		return srv.URL
+	}
+
+	chain := newTestChain(b, crlServer, crlServer)
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if revoked, ok, err := VerifyChain(chain); revoked || !ok || err != nil {
+				b.Errorf("expected a good chain, got revoked %t, ok %t, err %v", revoked, ok, err)
+			}
+		}
+	})
+}
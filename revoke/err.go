@@ -437,6 +437,59 @@ func WrapError(category Category, reason Reason, err error) *Error {
 
 }
 
+// Errors returned when checking the revocation status of a certificate, which can be matched using errors.Is.
 var (
+	ErrCertRevoked = errors.New("certificate revoked")
+
+	ErrCertExpired = errors.New("certificate expired")
+
+	ErrCertNotYetValid = errors.New("certificate isn't valid yet")
+
 	ErrFailedGetCRL = errors.New("failed to retrieve CRL")
+
+	ErrCRLIssuerMismatch = errors.New("CRL issuer doesn't match the certificate issuer")
+
+	ErrCRLNumberRegressed = errors.New("CRL number is lower than the cached CRL")
+
+	ErrInvalidDeltaCRL = errors.New("invalid delta CRL")
+
+	ErrBundleCRLExpired = errors.New("bundled CRL has expired")
+
+	ErrFailedGetOCSP = errors.New("failed to retrieve OCSP")
+
+	ErrOCSPUnauthorized = errors.New("OCSP unauthorized")
+
+	ErrOCSPMalformed = errors.New("OCSP malformed")
+
+	ErrOCSPInternalError = errors.New("OCSP internal error")
+
+	ErrOCSPTryLater = errors.New("OCSP try later")
+
+	ErrOCSPSignatureRequired = errors.New("OCSP signature required")
+
+	ErrOCSPStatusUnknown = errors.New("OCSP responder returned an unknown certificate status")
+
+	ErrOCSPNonceMismatch = errors.New("OCSP response nonce doesn't match the request")
+
+	ErrOCSPNonceMissing = errors.New("OCSP response is missing the request nonce")
+
+	ErrOCSPResponseStale = errors.New("OCSP response is not current")
+
+	ErrOCSPResponderUnauthorized = errors.New("OCSP response signed by an unauthorized responder")
+
+	ErrIssuerUnavailable = errors.New("issuer certificate could not be located")
+
+	ErrRevocationDisabled = errors.New("both CRL and OCSP checking are disabled")
+
+	ErrNoRevocationInfo = errors.New("certificate has no revocation information")
+
+	ErrResponseTooLarge = errors.New("response exceeds the maximum size")
+
+	ErrOfflineMode = errors.New("offline mode prevents fetching")
+
+	ErrHostDenied = errors.New("host denied by the host policy")
+
+	ErrAddressDenied = errors.New("connection to a private address denied")
+
+	ErrNoPeerCertificates = errors.New("no peer certificates to verify")
 )
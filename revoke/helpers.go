@@ -3,11 +3,31 @@ package revoke
 import (
 	"bytes"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"net/url"
 )
 
+// crlDER returns the DER encoding of the first X509 CRL block when the body is PEM encoded, and the body itself
+// otherwise.
+func crlDER(body []byte) []byte {
+	rest := body
+
+	for {
+		var block *pem.Block
+
+		if block, rest = pem.Decode(rest); block == nil {
+			return body
+		}
+
+		if block.Type == "X509 CRL" {
+			return block.Bytes
+		}
+	}
+}
+
 // ParseCertificatePEM parses and returns a PEM-encoded certificate,
 // can handle PEM encoded PKCS #7 structures.
 func ParseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
@@ -39,29 +59,120 @@ func ParseOneCertificateFromPEM(certsPEM []byte) ([]*x509.Certificate, []byte, e
 
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		var pkcs7data *PKCS7
+		certs, err := parsePKCS7Certificates(block.Bytes)
+
+		return certs, rest, err
+	}
+
+	return []*x509.Certificate{cert}, rest, nil
+}
+
+// parsePKCS7Certificates returns the certificates from a DER encoded PKCS #7
+// signed data structure, such as a .p7c certificate bundle.
+func parsePKCS7Certificates(der []byte) ([]*x509.Certificate, error) {
+	pkcs7data, err := ParsePKCS7(der)
+	if err != nil {
+		return nil, err
+	}
 
-		if pkcs7data, err = ParsePKCS7(block.Bytes); err != nil {
-			return nil, rest, err
+	if pkcs7data.ContentInfo != "SignedData" {
+		return nil, errors.New("only PKCS #7 Signed Data Content Info supported for certificate parsing")
+	}
+
+	certs := pkcs7data.Content.SignedData.Certificates
+	if certs == nil {
+		return nil, errors.New("PKCS #7 structure contains no certificates")
+	}
+
+	return certs, nil
+}
+
+// parseIssuerCertificates parses the certificates fetched from an Authority
+// Information Access URL, which may be a DER or PEM encoded certificate or a
+// PKCS #7 bundle of certificates.
+func parseIssuerCertificates(in []byte) ([]*x509.Certificate, error) {
+	if p, _ := pem.Decode(in); p != nil {
+		certs, _, err := ParseOneCertificateFromPEM(bytes.TrimSpace(in))
+		if err == nil && certs == nil {
+			err = NewError(CertificateError, DecodeFailed)
 		}
 
-		if pkcs7data.ContentInfo != "SignedData" {
-			return nil, rest, errors.New("only PKCS #7 Signed Data Content Info supported for certificate parsing")
+		return certs, err
+	}
+
+	if cert, err := x509.ParseCertificate(in); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+
+	return parsePKCS7Certificates(in)
+}
+
+// selectIssuer returns the certificate which issued cert from the certificates
+// fetched for it, or the only certificate if there's just one.
+func selectIssuer(cert *x509.Certificate, certs []*x509.Certificate) (*x509.Certificate, error) {
+	for _, issuer := range certs {
+		if !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			continue
 		}
 
-		certs := pkcs7data.Content.SignedData.Certificates
-		if certs == nil {
-			return nil, rest, errors.New("PKCS #7 structure contains no certificates")
+		if len(cert.AuthorityKeyId) != 0 && len(issuer.SubjectKeyId) != 0 && !bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+			continue
 		}
 
-		return certs, rest, nil
+		return issuer, nil
 	}
 
-	return []*x509.Certificate{cert}, rest, nil
+	if len(certs) == 1 {
+		return certs[0], nil
+	}
+
+	return nil, ErrIssuerUnavailable
 }
 
-// We can't handle LDAP certificates, so this checks to see if the
-// URL string points to an LDAP resource so that we can ignore it.
+// RevocationEndpoints lists the revocation related URLs a certificate advertises.
+type RevocationEndpoints struct {
+	// CRL is the CRL distribution points which are checked, and SkippedCRL is those which aren't, such as LDAP URLs
+	// when no fetcher was set with SetLDAPFetcher.
+	CRL        []string
+	SkippedCRL []string
+
+	// DeltaCRL is the delta CRL distribution points from the freshest CRL extension.
+	DeltaCRL []string
+
+	// OCSP is the OCSP servers and Issuer is the issuer certificate URLs from the Authority Information Access
+	// extension.
+	OCSP   []string
+	Issuer []string
+}
+
+// Endpoints returns the revocation related URLs the certificate advertises, for diagnosing revocation failures. It
+// doesn't fetch anything.
+func Endpoints(cert *x509.Certificate) RevocationEndpoints {
+	endpoints := RevocationEndpoints{
+		DeltaCRL: freshestCRLURLs(cert.Extensions),
+		OCSP:     cert.OCSPServer,
+		Issuer:   cert.IssuingCertificateURL,
+	}
+
+	for _, uri := range cert.CRLDistributionPoints {
+		if skipCRLURL(uri) {
+			endpoints.SkippedCRL = append(endpoints.SkippedCRL, uri)
+		} else {
+			endpoints.CRL = append(endpoints.CRL, uri)
+		}
+	}
+
+	return endpoints
+}
+
+// skipCRLURL returns true if the CRL at the URL can't be fetched, which is
+// the case for LDAP URLs unless a fetcher was set with SetLDAPFetcher.
+func skipCRLURL(uri string) bool {
+	return ldapFetcher == nil && ldapURL(uri)
+}
+
+// ldapURL checks to see if the URL string points to an LDAP resource, which
+// is fetched using the function set with SetLDAPFetcher rather than HTTP.
 func ldapURL(uri string) bool {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -74,3 +185,47 @@ func ldapURL(uri string) bool {
 
 	return false
 }
+
+var (
+	oidFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+)
+
+// Types used to parse the CRL distribution points syntax of the freshest CRL extension.
+
+type distributionPointName struct {
+	FullName     []asn1.RawValue  `asn1:"optional,tag:0"`
+	RelativeName pkix.RDNSequence `asn1:"optional,tag:1"`
+}
+
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+// freshestCRLURLs returns the URLs of the delta CRLs in the freshest CRL extension, if it's present in the extensions.
+func freshestCRLURLs(extensions []pkix.Extension) (urls []string) {
+	for _, ext := range extensions {
+		if !ext.Id.Equal(oidFreshestCRL) {
+			continue
+		}
+
+		var points []distributionPoint
+
+		if _, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+			return nil
+		}
+
+		for _, point := range points {
+			for _, name := range point.DistributionPoint.FullName {
+				// A uniformResourceIdentifier GeneralName.
+				if name.Tag == 6 {
+					urls = append(urls, string(name.Bytes))
+				}
+			}
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,150 @@
+package revoke
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// newTestCA returns a self-signed CA certificate and its key.
+func newTestCA(t testing.TB, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	return newTestCert(t, tpl, tpl, key, key), key
+}
+
+// newTestLeaf returns a certificate with the serial number issued by the CA, after applying mod to its template.
+func newTestLeaf(t testing.TB, ca *x509.Certificate, caKey *ecdsa.PrivateKey, serial int64, mod func(tpl *x509.Certificate)) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	if mod != nil {
+		mod(tpl)
+	}
+
+	return newTestCert(t, tpl, ca, key, caKey)
+}
+
+func newTestCert(t testing.TB, tpl, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// newTestCRL returns a DER encoded CRL issued by the CA revoking the serial numbers.
+func newTestCRL(t testing.TB, ca *x509.Certificate, caKey *ecdsa.PrivateKey, number int64, serials ...int64) []byte {
+	t.Helper()
+
+	tpl := &x509.RevocationList{
+		Number:     big.NewInt(number),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+
+	for _, serial := range serials {
+		tpl.RevokedCertificateEntries = append(tpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Minute),
+			ReasonCode:     1,
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tpl, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+// newTestOCSPResponse returns an OCSP response for the serial number signed by the responder, using the template for the status
+// and times. The update times default to a response which is currently valid.
+func newTestOCSPResponse(t testing.TB, issuer, responder *x509.Certificate, responderKey *ecdsa.PrivateKey, serial int64, tpl ocsp.Response) []byte {
+	t.Helper()
+
+	tpl.SerialNumber = big.NewInt(serial)
+
+	if tpl.ThisUpdate.IsZero() {
+		tpl.ThisUpdate = time.Now().Add(-time.Minute)
+	}
+
+	if tpl.NextUpdate.IsZero() {
+		tpl.NextUpdate = time.Now().Add(time.Hour)
+	}
+
+	if responder != issuer {
+		tpl.Certificate = responder
+	}
+
+	der, err := ocsp.CreateResponse(issuer, responder, tpl, responderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return der
+}
+
+// newTestOCSPServer returns the URL of a responder which serves the DER encoded OCSP response to every request.
+func newTestOCSPServer(t testing.TB, response []byte) string {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+// resetForTest clears the caches once the test finishes.
+func resetForTest(t testing.TB) {
+	t.Helper()
+
+	t.Cleanup(Reset)
+}
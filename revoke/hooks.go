@@ -0,0 +1,48 @@
+package revoke
+
+import "time"
+
+// Hooks are callbacks invoked while checking revocation, intended for recording metrics such as fetch counts and
+// latencies, cache hit rates, and revocation outcomes. Any of them may be nil. They're called synchronously, so they
+// should return quickly.
+type Hooks struct {
+	// OnCRLFetch is called after each attempt to fetch a CRL, with the time it took and any error. A CRL which the
+	// server reported as unchanged is reported without an error.
+	OnCRLFetch func(uri string, dur time.Duration, err error)
+
+	// OnOCSPFetch is called after each OCSP request, with the time it took and the status from the response, which is
+	// one of ocsp.Good, ocsp.Revoked, or ocsp.Unknown, or -1 if the request failed.
+	OnOCSPFetch func(server string, dur time.Duration, status int)
+
+	// OnCacheLookup is called each time the CRL or OCSP cache is consulted, whether or not it has a usable entry. The
+	// kind is URLKindCRL or URLKindOCSP.
+	OnCacheLookup func(kind string, hit bool)
+
+	// OnResult is called with the result of each certificate's revocation check, excluding stapled OCSP responses.
+	OnResult func(result *CheckResult, err error)
+}
+
+var hooks Hooks
+
+// SetHooks sets the Hooks invoked while checking revocation. Setting it to the zero value disables them.
+func SetHooks(h Hooks) {
+	hooks = h
+}
+
+func hookCRLFetch(uri string, start time.Time, err error) {
+	if hooks.OnCRLFetch != nil {
+		hooks.OnCRLFetch(uri, time.Since(start), err)
+	}
+}
+
+func hookOCSPFetch(server string, start time.Time, status int) {
+	if hooks.OnOCSPFetch != nil {
+		hooks.OnOCSPFetch(server, time.Since(start), status)
+	}
+}
+
+func hookCacheLookup(kind string, hit bool) {
+	if hooks.OnCacheLookup != nil {
+		hooks.OnCacheLookup(kind, hit)
+	}
+}
@@ -0,0 +1,286 @@
+package revoke
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SetTLSConfig replaces the HTTPClient with a copy whose transport uses the TLS configuration, such as a private root
+// or a client certificate for distribution points served over mutual TLS. The rest of the client and its transport
+// are kept, so it can be combined with a custom HTTPClient set beforehand. It fails if the HTTPClient's transport
+// isn't an *http.Transport, in which case the TLS configuration must be set on that transport directly.
+func SetTLSConfig(cfg *tls.Config) error {
+	var transport *http.Transport
+
+	switch rt := HTTPClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = rt.Clone()
+	default:
+		return fmt.Errorf("can't set the TLS configuration of a %T transport", rt)
+	}
+
+	transport.TLSClientConfig = cfg
+
+	client := *HTTPClient
+	client.Transport = transport
+
+	HTTPClient = &client
+
+	return nil
+}
+
+// BlockPrivateAddresses replaces the HTTPClient with a copy whose transport refuses to connect to loopback, private,
+// link-local, and unspecified addresses, which prevents a certificate's CRL, OCSP, or issuer URLs from reaching
+// internal services. The address is checked after the host name is resolved, so a public name which resolves to a
+// private address is refused, and it applies to redirects as they use the same transport. The transport's dialer is
+// replaced, and connections made through a proxy are checked against the proxy's address rather than the target's.
+// It fails if the HTTPClient's transport isn't an *http.Transport.
+func BlockPrivateAddresses() error {
+	var transport *http.Transport
+
+	switch rt := HTTPClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = rt.Clone()
+	default:
+		return fmt.Errorf("can't block private addresses on a %T transport", rt)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkDialAddress,
+	}
+
+	transport.DialContext = dialer.DialContext
+
+	client := *HTTPClient
+	client.Transport = transport
+
+	HTTPClient = &client
+
+	return nil
+}
+
+// checkDialAddress refuses a connection to a resolved address which is loopback, private, link-local, or unspecified.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrAddressDenied, address, err)
+	}
+
+	addr := addrPort.Addr().Unmap()
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrAddressDenied, addr)
+	}
+
+	return nil
+}
+
+// withFetchTimeout applies the FetchTimeout to the context of a single fetch.
+func withFetchTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if FetchTimeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, FetchTimeout)
+}
+
+// limitResponse limits the response body to MaxResponseSize bytes.
+func limitResponse(body io.Reader) io.Reader {
+	if MaxResponseSize <= 0 {
+		return body
+	}
+
+	return &limitedReader{r: body, remaining: MaxResponseSize + 1}
+}
+
+// limitedReader reads from r until it has read more bytes than it was limited to, after which it returns
+// ErrResponseTooLarge. The remaining count starts at one more than the limit so a body of exactly the limit is read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (n int, err error) {
+	if l.remaining <= 0 {
+		return 0, ErrResponseTooLarge
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err = l.r.Read(p)
+
+	if l.remaining -= int64(n); l.remaining <= 0 {
+		return n, ErrResponseTooLarge
+	}
+
+	return n, err
+}
+
+// decodeResponse returns a reader which decompresses the response body according to its gzip or deflate
+// Content-Encoding, or the body itself if it isn't encoded. A deflate body may be zlib wrapped or raw, as both are
+// served in practice.
+func decodeResponse(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		br := bufio.NewReader(resp.Body)
+
+		if header, err := br.Peek(2); err == nil && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 && header[0]&0x0f == 8 {
+			return zlib.NewReader(br)
+		}
+
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+// httpGet performs a GET request using the HTTPClient and the context.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	return httpGetHeader(ctx, url, nil)
+}
+
+// httpGetHeader performs a GET request with the additional headers using the HTTPClient and the context.
+func httpGetHeader(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	return doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		return req, nil
+	})
+}
+
+// httpPost performs a POST request using the HTTPClient and the context.
+func httpPost(ctx context.Context, url string, header http.Header, body []byte) (*http.Response, error) {
+	return doRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		return req, nil
+	})
+}
+
+// doRequest performs the request created by newRequest using the HTTPClient, retrying it up to FetchRetries times
+// with an exponential backoff when it fails with a network error or a server error response.
+func doRequest(ctx context.Context, newRequest func() (*http.Request, error)) (resp *http.Response, err error) {
+	backoff := FetchRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		var req *http.Request
+
+		if req, err = newRequest(); err != nil {
+			return nil, err
+		}
+
+		if OfflineMode {
+			return nil, fmt.Errorf("%w: %s", ErrOfflineMode, req.URL)
+		}
+
+		if err = checkHostPolicy(req.URL); err != nil {
+			return nil, err
+		}
+
+		if UserAgent != "" {
+			req.Header.Set("User-Agent", UserAgent)
+		}
+
+		resp, err = redirectClient().Do(req)
+
+		if attempt >= FetchRetries || ctx.Err() != nil || (err == nil && resp.StatusCode < 500) {
+			return resp, err
+		}
+
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// checkHostPolicy checks the URL against the function set with SetHostPolicy.
+func checkHostPolicy(u *url.URL) error {
+	if hostPolicy == nil {
+		return nil
+	}
+
+	if err := hostPolicy(u); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrHostDenied, u.Host, err)
+	}
+
+	return nil
+}
+
+// redirectClient returns a copy of the HTTPClient which enforces MaxRedirects and SameHostRedirects before applying
+// the client's own redirect policy.
+func redirectClient() *http.Client {
+	client := *HTTPClient
+	check := HTTPClient.CheckRedirect
+
+	maxRedirects, sameHost := MaxRedirects, SameHostRedirects
+
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+
+		if sameHost && req.URL.Host != via[0].URL.Host {
+			return fmt.Errorf("redirect from %s to a different host %s", via[0].URL.Host, req.URL.Host)
+		}
+
+		if err := checkHostPolicy(req.URL); err != nil {
+			return err
+		}
+
+		if check != nil {
+			return check(req, via)
+		}
+
+		return nil
+	}
+
+	return &client
+}
@@ -0,0 +1,94 @@
+package revoke
+
+import (
+	"bytes"
+	"crypto/x509"
+	"sync"
+)
+
+var (
+	// CacheIssuers determines whether issuer certificates fetched from the URLs in a certificate's Authority
+	// Information Access extension are cached by URL until they expire, rather than fetched for every check.
+	CacheIssuers = true
+
+	issuerPool []*x509.Certificate
+
+	issuerResolver func(cert *x509.Certificate) (*x509.Certificate, error)
+
+	issuerLock = new(sync.RWMutex)
+
+	issuerCache = map[string]*x509.Certificate{}
+
+	issuerCacheLock = new(sync.RWMutex)
+)
+
+// ClearIssuerCache removes every fetched issuer certificate from the cache. Issuers set with SetIssuers are kept.
+func ClearIssuerCache() {
+	issuerCacheLock.Lock()
+	defer issuerCacheLock.Unlock()
+
+	for url := range issuerCache {
+		delete(issuerCache, url)
+	}
+}
+
+// issuerCacheGet returns the cached issuer certificate fetched from the URL if there is one and it hasn't expired.
+func issuerCacheGet(url string) *x509.Certificate {
+	issuerCacheLock.RLock()
+	issuer, ok := issuerCache[url]
+	issuerCacheLock.RUnlock()
+
+	if !ok || !now().Before(issuer.NotAfter) {
+		return nil
+	}
+
+	return issuer
+}
+
+// issuerCachePut caches the issuer certificate fetched from the URL.
+func issuerCachePut(url string, issuer *x509.Certificate) {
+	issuerCacheLock.Lock()
+	issuerCache[url] = issuer
+	issuerCacheLock.Unlock()
+}
+
+// SetIssuers sets the pool of known issuer certificates, replacing any previously set. The pool is searched for the
+// issuer of a certificate before it's fetched from the URLs in the certificate's Authority Information Access
+// extension, which avoids the fetch entirely when the intermediates are already known, such as from a TLS handshake.
+func SetIssuers(certs ...*x509.Certificate) {
+	issuerLock.Lock()
+	issuerPool = append([]*x509.Certificate(nil), certs...)
+	issuerLock.Unlock()
+}
+
+// SetIssuerResolver sets a function which looks up the issuer of a certificate, such as from a directory or database of
+// intermediates. It's consulted after the pool set with SetIssuers and before fetching the issuer from the URLs in the
+// certificate's Authority Information Access extension. When it returns nil without an error the issuer is fetched as
+// usual, but when it returns an error the issuer is treated as unavailable. Setting it to nil disables it.
+func SetIssuerResolver(fn func(cert *x509.Certificate) (*x509.Certificate, error)) {
+	issuerResolver = fn
+}
+
+// poolIssuer returns the certificate from the issuer pool which issued the certificate, or nil if there isn't one. The
+// authority key identifier is matched against the subject key identifier when both are present, otherwise the issuer
+// name is matched against the subject name.
+func poolIssuer(cert *x509.Certificate) *x509.Certificate {
+	issuerLock.RLock()
+	defer issuerLock.RUnlock()
+
+	for _, issuer := range issuerPool {
+		if len(cert.AuthorityKeyId) != 0 && len(issuer.SubjectKeyId) != 0 {
+			if bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+				return issuer
+			}
+
+			continue
+		}
+
+		if bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+			return issuer
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package revoke
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPWithLocalIssuer(t *testing.T) {
+	testCases := []struct {
+		name  string
+		setup func(ca *x509.Certificate)
+	}{
+		{
+			name:  "Pool",
+			setup: func(ca *x509.Certificate) { SetIssuers(ca) },
+		},
+		{
+			name: "Resolver",
+			setup: func(ca *x509.Certificate) {
+				SetIssuerResolver(func(*x509.Certificate) (*x509.Certificate, error) { return ca, nil })
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetForTest(t)
+
+			t.Cleanup(func() {
+				SetIssuers()
+				SetIssuerResolver(nil)
+			})
+
+			ca, caKey := newTestCA(t, "ca")
+
+			server := newTestOCSPServer(t, newTestOCSPResponse(t, ca, ca, caKey, 2, ocsp.Response{Status: ocsp.Revoked}))
+
+			// The certificate has no Authority Information Access issuer URL.
+			leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+				tpl.OCSPServer = []string{server}
+			})
+
+			tc.setup(ca)
+
+			result, err := Check(leaf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !result.Revoked || !result.Determined || result.Method != MethodOCSP {
+				t.Fatalf("expected OCSP to find the certificate revoked, got %+v", result)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package revoke
+
+// Logger receives events emitted while checking revocation, such as cache hits and misses, fetch failures which are
+// otherwise swallowed, signature verification failures, and the final decision for each certificate. Each method is
+// given a message followed by alternating keys and values.
+type Logger interface {
+	Debug(msg string, keyvals ...any)
+	Warn(msg string, keyvals ...any)
+	Error(msg string, keyvals ...any)
+}
+
+// logger is the Logger events are emitted to. Every call site checks it's non-nil first so no arguments are allocated
+// when logging is disabled.
+var logger Logger
+
+// SetLogger sets the Logger which receives events emitted while checking revocation. Setting it to nil, the default,
+// disables logging.
+func SetLogger(l Logger) {
+	logger = l
+}
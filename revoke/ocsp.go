@@ -0,0 +1,254 @@
+package revoke
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	// OCSPNonce determines whether a random nonce is included in OCSP requests and checked against the nonce echoed
+	// in the response, protecting against replayed responses.
+	OCSPNonce = false
+
+	// OCSPNonceStrict determines whether an OCSP response which doesn't echo the nonce is rejected. When it's false
+	// such responses are accepted, though a response with a different nonce is always rejected.
+	OCSPNonceStrict = false
+
+	// ClockSkew is the tolerance applied when checking an OCSP response is current, allowing for differences between
+	// the local clock and the responder's.
+	ClockSkew time.Duration
+
+	oidOCSPNonce   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+	oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+	oidOCSPArchiveCutoff = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 6}
+)
+
+// Types used to add extensions to the OCSP requests created by the ocsp package.
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+	Extensions  []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+// Type used to read the response extensions, which the ocsp package doesn't expose.
+
+type ocspResponseData struct {
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      asn1.RawValue
+	Extensions     []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// createOCSPRequest creates a DER encoded OCSP request for the certificate using the hash algorithm. When OCSPNonce
+// is true a random nonce is added to the request and returned, otherwise the request is reused for later checks of
+// the certificate.
+func createOCSPRequest(leaf, issuer *x509.Certificate, hash crypto.Hash) (req, nonce []byte, err error) {
+	if !OCSPNonce {
+		if req = ocspRequestCacheGet(leaf, issuer, hash); req != nil {
+			return req, nil, nil
+		}
+	}
+
+	if req, err = ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: hash}); err != nil {
+		return nil, nil, err
+	}
+
+	if !OCSPNonce {
+		ocspRequestCachePut(leaf, issuer, hash, req)
+
+		return req, nil, nil
+	}
+
+	var request ocspRequest
+
+	if _, err = asn1.Unmarshal(req, &request); err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, 16)
+
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	request.TBSRequest.Extensions = append(request.TBSRequest.Extensions, pkix.Extension{Id: oidOCSPNonce, Value: value})
+
+	if req, err = asn1.Marshal(request); err != nil {
+		return nil, nil, err
+	}
+
+	return req, nonce, nil
+}
+
+// ocspResponseExtensions returns the extensions of the response as a whole, as opposed to the extensions of the single
+// response for the certificate which the ocsp package exposes as the Extensions of the response.
+func ocspResponseExtensions(resp *ocsp.Response) []pkix.Extension {
+	var data ocspResponseData
+
+	if _, err := asn1.Unmarshal(resp.TBSResponseData, &data); err != nil {
+		return nil
+	}
+
+	return data.Extensions
+}
+
+// ocspArchiveCutoff returns the archive cutoff from the response for the certificate, or the zero time if it has none.
+func ocspArchiveCutoff(resp *ocsp.Response) time.Time {
+	for _, ext := range resp.Extensions {
+		if !ext.Id.Equal(oidOCSPArchiveCutoff) {
+			continue
+		}
+
+		var cutoff time.Time
+
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &cutoff, "generalized"); err == nil {
+			return cutoff
+		}
+	}
+
+	return time.Time{}
+}
+
+// checkOCSPNonce ensures the response echoes the nonce sent in the request. A nil nonce means none was sent. The nonce
+// belongs in the response extensions, but responders which echo it in the single response extensions are accepted.
+func checkOCSPNonce(resp *ocsp.Response, nonce []byte) error {
+	if nonce == nil {
+		return nil
+	}
+
+	for _, ext := range append(ocspResponseExtensions(resp), resp.Extensions...) {
+		if !ext.Id.Equal(oidOCSPNonce) {
+			continue
+		}
+
+		var value []byte
+
+		// Some responders echo the nonce without wrapping it in an OCTET STRING.
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil {
+			value = ext.Value
+		}
+
+		if !bytes.Equal(value, nonce) {
+			return ErrOCSPNonceMismatch
+		}
+
+		return nil
+	}
+
+	if OCSPNonceStrict {
+		return ErrOCSPNonceMissing
+	}
+
+	return nil
+}
+
+// checkOCSPFresh ensures the current time is within the validity period of the response, give or take ClockSkew. A
+// response without a next update time is valid from its this update time onwards.
+func checkOCSPFresh(resp *ocsp.Response) error {
+	current := now()
+
+	if current.Before(resp.ThisUpdate.Add(-ClockSkew)) || (!resp.NextUpdate.IsZero() && !current.Before(resp.NextUpdate.Add(ClockSkew))) {
+		return ErrOCSPResponseStale
+	}
+
+	return nil
+}
+
+// checkOCSPResponder ensures a response signed by a delegated responder is authorized by the issuer. The ocsp package
+// only checks the responder certificate was signed by the issuer, so this also requires it to be current and to have
+// the OCSP signing extended key usage. Its validity period is checked give or take ClockSkew, like the response's. A
+// response signed by the issuer itself needs no further checks.
+func checkOCSPResponder(resp *ocsp.Response, issuer *x509.Certificate) error {
+	responder := resp.Certificate
+	if responder == nil || bytes.Equal(responder.Raw, issuer.Raw) {
+		return nil
+	}
+
+	if !bytes.Equal(responder.RawIssuer, issuer.RawSubject) {
+		return fmt.Errorf("%w: responder certificate wasn't issued by the issuer", ErrOCSPResponderUnauthorized)
+	}
+
+	current := now()
+
+	if current.Before(responder.NotBefore.Add(-ClockSkew)) || current.After(responder.NotAfter.Add(ClockSkew)) {
+		return fmt.Errorf("%w: responder certificate isn't valid at %s", ErrOCSPResponderUnauthorized, current)
+	}
+
+	for _, usage := range responder.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: responder certificate doesn't have the OCSP signing extended key usage", ErrOCSPResponderUnauthorized)
+}
+
+// checkOCSPResponderRevoked checks a delegated responder certificate against its CRLs, unless it has the
+// id-pkix-ocsp-nocheck extension which tells clients to trust it for its lifetime. Checking it using OCSP would rely on
+// the responder vouching for itself, so only CRLs are used.
+func checkOCSPResponderRevoked(ctx context.Context, resp *ocsp.Response, issuer *x509.Certificate) error {
+	responder := resp.Certificate
+	if responder == nil || bytes.Equal(responder.Raw, issuer.Raw) {
+		return nil
+	}
+
+	for _, ext := range responder.Extensions {
+		if ext.Id.Equal(oidOCSPNoCheck) {
+			return nil
+		}
+	}
+
+	if DisableCRL {
+		return nil
+	}
+
+	for _, uri := range responder.CRLDistributionPoints {
+		if skipCRLURL(uri) {
+			continue
+		}
+
+		revoked, ok, err := certIsRevokedCRL(ctx, responder, issuer, uri, &CheckResult{Certificate: responder})
+		if !ok {
+			return err
+		}
+
+		if revoked {
+			return fmt.Errorf("%w: responder certificate is revoked per CRL at %s", ErrOCSPResponderUnauthorized, uri)
+		}
+	}
+
+	return nil
+}
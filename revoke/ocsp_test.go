@@ -0,0 +1,248 @@
+package revoke
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPStatuses(t *testing.T) {
+	testCases := []struct {
+		name    string
+		status  int
+		strict  bool
+		revoked bool
+		ok      bool
+		err     error
+	}{
+		{name: "Good", status: ocsp.Good, ok: true},
+		{name: "Revoked", status: ocsp.Revoked, revoked: true, ok: true},
+		{name: "Unknown", status: ocsp.Unknown, err: ErrOCSPStatusUnknown},
+		{name: "UnknownStrict", status: ocsp.Unknown, strict: true, err: ErrOCSPStatusUnknown},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetForTest(t)
+
+			ca, caKey := newTestCA(t, "ca")
+
+			server := newTestOCSPServer(t, newTestOCSPResponse(t, ca, ca, caKey, 2, ocsp.Response{Status: tc.status, RevocationReason: ocsp.KeyCompromise}))
+
+			leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+				tpl.OCSPServer = []string{server}
+			})
+
+			result := &CheckResult{}
+
+			revoked, ok, err := certIsRevokedOCSP(context.Background(), leaf, ca, tc.strict, result)
+			if revoked != tc.revoked || ok != tc.ok {
+				t.Fatalf("expected revoked %t and ok %t, got %t and %t", tc.revoked, tc.ok, revoked, ok)
+			}
+
+			if tc.err == nil && err != nil || tc.err != nil && !errors.Is(err, tc.err) {
+				t.Fatalf("expected error %v, got %v", tc.err, err)
+			}
+
+			if tc.revoked && result.Reason != ocsp.KeyCompromise {
+				t.Fatalf("expected the revocation reason to be recorded, got %d", result.Reason)
+			}
+		})
+	}
+}
+
+// newTestResponder returns a delegated OCSP responder certificate issued by the CA and its key, after applying mod to
+// its template.
+func newTestResponder(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, mod func(tpl *x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(100),
+		Subject:      pkix.Name{CommonName: "responder"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}
+
+	if mod != nil {
+		mod(tpl)
+	}
+
+	return newTestCert(t, tpl, ca, key, caKey), key
+}
+
+func TestOCSPDelegatedResponder(t *testing.T) {
+	noCheck := pkix.Extension{Id: oidOCSPNoCheck, Value: asn1.NullBytes}
+
+	testCases := []struct {
+		name string
+		mod  func(tpl *x509.Certificate, crl string)
+		err  error
+	}{
+		{
+			name: "Authorized",
+			mod: func(tpl *x509.Certificate, _ string) {
+				tpl.ExtraExtensions = []pkix.Extension{noCheck}
+			},
+		},
+		{
+			name: "MissingOCSPSigning",
+			mod: func(tpl *x509.Certificate, _ string) {
+				tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+			},
+			err: ErrOCSPResponderUnauthorized,
+		},
+		{
+			name: "RevokedResponder",
+			mod: func(tpl *x509.Certificate, crl string) {
+				tpl.CRLDistributionPoints = []string{crl}
+			},
+			err: ErrOCSPResponderUnauthorized,
+		},
+		{
+			name: "RevokedResponderWithNoCheck",
+			mod: func(tpl *x509.Certificate, crl string) {
+				tpl.CRLDistributionPoints = []string{crl}
+				tpl.ExtraExtensions = []pkix.Extension{noCheck}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetForTest(t)
+
+			ca, caKey := newTestCA(t, "ca")
+
+			// A CRL revoking the responder, which is only consulted when it lacks the nocheck extension.
+			crlSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(newTestCRL(t, ca, caKey, 1, 100))
+			}))
+			defer crlSrv.Close()
+
+			responder, responderKey := newTestResponder(t, ca, caKey, func(tpl *x509.Certificate) {
+				tc.mod(tpl, crlSrv.URL)
+			})
+
+			server := newTestOCSPServer(t, newTestOCSPResponse(t, ca, responder, responderKey, 2, ocsp.Response{Status: ocsp.Good}))
+
+			leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+				tpl.OCSPServer = []string{server}
+			})
+
+			revoked, ok, err := certIsRevokedOCSP(context.Background(), leaf, ca, false, &CheckResult{})
+
+			if tc.err == nil {
+				if err != nil || revoked || !ok {
+					t.Fatalf("expected the delegated response to be trusted, got %t, %t, %v", revoked, ok, err)
+				}
+
+				return
+			}
+
+			if ok || !errors.Is(err, tc.err) {
+				t.Fatalf("expected the delegated response to be rejected with %v, got %t, %v", tc.err, ok, err)
+			}
+		})
+	}
+}
+
+func TestOCSPExpiredResponder(t *testing.T) {
+	testCases := []struct {
+		name      string
+		clockSkew time.Duration
+		trusted   bool
+	}{
+		{name: "Rejected"},
+		{name: "WithinClockSkew", clockSkew: 2 * time.Minute, trusted: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetForTest(t)
+
+			ClockSkew = tc.clockSkew
+			t.Cleanup(func() { ClockSkew = 0 })
+
+			ca, caKey := newTestCA(t, "ca")
+
+			responder, responderKey := newTestResponder(t, ca, caKey, func(tpl *x509.Certificate) {
+				tpl.NotAfter = time.Now().Add(-time.Minute)
+				tpl.ExtraExtensions = []pkix.Extension{{Id: oidOCSPNoCheck, Value: asn1.NullBytes}}
+			})
+
+			server := newTestOCSPServer(t, newTestOCSPResponse(t, ca, responder, responderKey, 2, ocsp.Response{Status: ocsp.Good}))
+
+			leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+				tpl.OCSPServer = []string{server}
+			})
+
+			_, ok, err := certIsRevokedOCSP(context.Background(), leaf, ca, false, &CheckResult{})
+
+			if tc.trusted {
+				if !ok || err != nil {
+					t.Fatalf("expected the response to be trusted within the clock skew, got %t, %v", ok, err)
+				}
+
+				return
+			}
+
+			if ok || !errors.Is(err, ErrOCSPResponderUnauthorized) {
+				t.Fatalf("expected the expired responder to be rejected, got %t, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestOCSPArchiveCutoff(t *testing.T) {
+	resetForTest(t)
+
+	ca, caKey := newTestCA(t, "ca")
+
+	cutoff := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	value, err := asn1.MarshalWithParams(cutoff, "generalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestOCSPServer(t, newTestOCSPResponse(t, ca, ca, caKey, 2, ocsp.Response{
+		Status:          ocsp.Good,
+		ExtraExtensions: []pkix.Extension{{Id: oidOCSPArchiveCutoff, Value: value}},
+	}))
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.OCSPServer = []string{server}
+	})
+
+	result, err := CheckOCSP(context.Background(), leaf, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Determined || !result.ArchiveCutoff.Equal(cutoff) {
+		t.Fatalf("expected the archive cutoff %s, got %+v", cutoff, result)
+	}
+
+	// The cutoff is also reported when the response is served from the cache.
+	if result, err = CheckOCSP(context.Background(), leaf, ca); err != nil || !result.FromCache || !result.ArchiveCutoff.Equal(cutoff) {
+		t.Fatalf("expected the cached archive cutoff %s, got %+v, %v", cutoff, result, err)
+	}
+}
@@ -0,0 +1,108 @@
+package revoke
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// The methods which can decide the outcome of a check, reported in the Method field of a CheckResult.
+const (
+	MethodValidity    = "validity"
+	MethodCRL         = "crl"
+	MethodOCSP        = "ocsp"
+	MethodStapledOCSP = "ocsp-stapled"
+)
+
+// CheckResult describes the outcome of checking a certificate, including which certificate and which mechanism
+// decided it. The Revoked and Determined fields have the same meaning as the revoked and ok values returned by
+// VerifyCertificateError. The Method is empty when the certificate has no revocation information. The Rationale is a
+// human readable explanation of why the outcome was reached, suitable for displaying to operators or logging.
+type CheckResult struct {
+	Certificate *x509.Certificate
+	Revoked     bool
+	Determined  bool
+	Method      string
+	Rationale   string
+
+	// Reason is the revocation reason code from RFC 5280 and RevokedAt is the time of the revocation, both of which
+	// are only set when the certificate was revoked by a CRL or OCSP response.
+	Reason    int
+	RevokedAt time.Time
+
+	// ArchiveCutoff is the archive cutoff from an OCSP response, the earliest revocation time for which the responder
+	// retains status information, which is used for long term validation of expired certificates. It's the zero time
+	// unless the outcome was decided by an OCSP response which included it.
+	ArchiveCutoff time.Time
+
+	// CheckedAt is the time the check was made.
+	CheckedAt time.Time
+
+	// Expired is true when the certificate has expired but was still checked for revocation because it's within the
+	// ExpiryGrace period.
+	Expired bool
+
+	// FromCache is true when every CRL and OCSP response the outcome was based on was served from the cache, so the
+	// check made no requests.
+	FromCache bool
+
+	fetched bool
+}
+
+// noteCache records whether a CRL or OCSP response used by the check was served from the cache.
+func (r *CheckResult) noteCache(hit bool) {
+	if !hit {
+		r.fetched = true
+	}
+
+	r.FromCache = !r.fetched
+}
+
+// RevocationInfo returns the revocation details from the result.
+func (r *CheckResult) RevocationInfo() *RevocationInfo {
+	return &RevocationInfo{
+		Revoked:   r.Revoked,
+		Reason:    r.Reason,
+		RevokedAt: r.RevokedAt,
+		Source:    r.Method,
+	}
+}
+
+// RevocationInfo describes the revocation status of a certificate. The Source is the method which determined it, such
+// as MethodCRL or MethodOCSP.
+type RevocationInfo struct {
+	Revoked   bool
+	Reason    int
+	RevokedAt time.Time
+	Source    string
+}
+
+// ReasonString returns the name of the RFC 5280 revocation reason code.
+func ReasonString(reason int) string {
+	switch reason {
+	case ocsp.Unspecified:
+		return "unspecified"
+	case ocsp.KeyCompromise:
+		return "keyCompromise"
+	case ocsp.CACompromise:
+		return "cACompromise"
+	case ocsp.AffiliationChanged:
+		return "affiliationChanged"
+	case ocsp.Superseded:
+		return "superseded"
+	case ocsp.CessationOfOperation:
+		return "cessationOfOperation"
+	case ocsp.CertificateHold:
+		return "certificateHold"
+	case ocsp.RemoveFromCRL:
+		return "removeFromCRL"
+	case ocsp.PrivilegeWithdrawn:
+		return "privilegeWithdrawn"
+	case ocsp.AACompromise:
+		return "aACompromise"
+	default:
+		return fmt.Sprintf("unknown(%d)", reason)
+	}
+}
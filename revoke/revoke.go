@@ -2,15 +2,17 @@ package revoke
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,50 +34,309 @@ import (
 //
 //	true, false:  failure to check revocation status causes
 //	                verification to fail
-func revCheck(cert *x509.Certificate) (revoked, ok bool, err error) {
+//
+// The pair is reported as the Revoked and Determined fields of the result. When issuer is nil it's fetched using the
+// certificate's Authority Information Access extension.
+func revCheck(ctx context.Context, cert, issuer *x509.Certificate) (result *CheckResult, err error) {
+	result = &CheckResult{Certificate: cert, CheckedAt: now()}
+
+	if hooks.OnResult != nil {
+		defer func() { hooks.OnResult(result, err) }()
+	}
+
+	if logger != nil {
+		defer func() {
+			if result.Determined {
+				logger.Debug("revocation check decided", "serial", cert.SerialNumber, "revoked", result.Revoked, "method", result.Method, "rationale", result.Rationale)
+			} else {
+				logger.Warn("revocation check undetermined", "serial", cert.SerialNumber, "revoked", result.Revoked, "method", result.Method, "rationale", result.Rationale, "err", err)
+			}
+		}()
+	}
+
+	if DisableCRL && DisableOCSP {
+		result.Revoked = HardFail
+		result.Rationale = "CRL and OCSP checking are both disabled"
+
+		return result, ErrRevocationDisabled
+	}
+
+	if CheckTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, CheckTimeout)
+		defer cancel()
+	}
+
+	var issuerErr error
+
+	if issuer == nil && ((!DisableCRL && len(cert.CRLDistributionPoints) != 0) || (!DisableOCSP && len(ocspServers(cert)) != 0)) {
+		issuer, issuerErr = getIssuer(ctx, cert)
+	}
+
+	var (
+		checked, sources, rationales []string
+		done, fellBack               bool
+		method                       string
+		errs                         []error
+	)
+
+	// undetermined records that the sources which couldn't be checked left the outcome undecided.
+	undetermined := func() (*CheckResult, error) {
+		result.Revoked, result.Determined = HardFail, false
+		result.Method = method
+		result.Rationale = strings.Join(rationales, "; ")
+
+		// Failing to locate the issuer is often why the check couldn't be completed.
+		return result, joinErrors(append(errs, issuerErr)...)
+	}
+
+	checks := []func(context.Context, *x509.Certificate, *x509.Certificate, *CheckResult) ([]string, bool, error){
+		revCheckCRL, revCheckOCSP,
+	}
+
+	if PreferOCSP {
+		checks[0], checks[1] = checks[1], checks[0]
+	}
+
+	for i, check := range checks {
+		sources, done, err = check(ctx, cert, issuer, result)
+
+		if done && result.Determined {
+			return result, err
+		}
+
+		// In fail-open mode the remaining sources are still checked after one couldn't be, as they may find the
+		// certificate revoked.
+		if done || err != nil {
+			if len(errs) == 0 {
+				method = result.Method
+			}
+
+			errs, rationales = append(errs, err), append(rationales, result.Rationale)
+		}
+
+		if done {
+			// With PreferOCSP an OCSP failure falls back to the CRLs in fail-open mode rather than deciding the outcome.
+			// In hard-fail mode it's decided as it would be without PreferOCSP.
+			if PreferOCSP && !HardFail && i == 0 {
+				fellBack = true
+
+				continue
+			}
+
+			return undetermined()
+		}
+
+		checked = append(checked, sources...)
+
+		// With PreferOCSP a definite answer from OCSP is final, so the CRLs aren't fetched.
+		if PreferOCSP && i == 0 && len(sources) != 0 {
+			break
+		}
+	}
+
+	// With PreferOCSP the CRLs decide the outcome when OCSP couldn't, unless they couldn't either or there are none.
+	if len(errs) != 0 && (!fellBack || len(errs) != 1 || len(checked) == 0) {
+		return undetermined()
+	}
+
+	if len(checked) == 0 && RequireRevocationInfo {
+		result.Revoked = HardFail
+		result.Rationale = "no revocation information, which is required"
+
+		return result, ErrNoRevocationInfo
+	}
+
+	result.Revoked, result.Determined = false, true
+
+	if len(checked) == 0 {
+		result.Rationale = "no revocation information, treated as valid"
+	} else {
+		result.Rationale = "not revoked per " + strings.Join(checked, " and ")
+	}
+
+	return result, nil
+}
+
+// revCheckCRL checks the certificate against each of its CRL distribution points. It returns done when the outcome has
+// been decided and recorded in the result, otherwise it returns the sources which were checked. In fail-open mode the
+// distribution points which couldn't be checked are recorded in the result and their errors returned without done, so
+// the remaining sources can still be checked.
+func revCheckCRL(ctx context.Context, cert, issuer *x509.Certificate, result *CheckResult) (checked []string, done bool, err error) {
+	if DisableCRL {
+		return nil, false, nil
+	}
+
+	uris := make([]string, 0, len(cert.CRLDistributionPoints))
+
 	for _, uri := range cert.CRLDistributionPoints {
-		if ldapURL(uri) {
-			continue
+		if !skipCRLURL(uri) {
+			uris = append(uris, uri)
 		}
+	}
 
-		if revoked, ok, err = certIsRevokedCRL(cert, uri); !ok {
+	// Fall back to a CRL loaded with LoadCRLBundle for the certificate's issuer.
+	if len(uris) == 0 {
+		if key, ok := bundleCRL(cert); ok {
+			uris = append(uris, key)
+		}
+	}
+
+	var (
+		failed []string
+		errs   []error
+	)
+
+	for _, uri := range uris {
+		result.Method = MethodCRL
+
+		if result.Revoked, result.Determined, err = certIsRevokedCRL(ctx, cert, issuer, uri, result); !result.Determined {
 			if HardFail {
-				return true, false, err
+				result.Revoked = true
+				result.Rationale = undeterminedRationale(fmt.Sprintf("CRL at %s", uri), err)
+
+				return nil, true, err
 			}
-			return false, false, err
-		} else if revoked {
-			return true, true, err
+
+			// In fail-open mode the other distribution points are still checked, as one of them may find the cert
+			// revoked.
+			failed, errs = append(failed, "CRL at "+uri), append(errs, err)
+
+			continue
+		} else if result.Revoked {
+			result.Rationale = fmt.Sprintf("revoked per CRL at %s reason %s", uri, ReasonString(result.Reason))
+
+			return nil, true, err
 		}
+
+		checked = append(checked, "CRL at "+uri)
+	}
+
+	if len(failed) != 0 {
+		err = joinErrors(errs...)
+
+		result.Method = MethodCRL
+		result.Revoked, result.Determined = false, false
+
+		if len(failed) == 1 {
+			result.Rationale = undeterminedRationale(failed[0], err)
+		} else {
+			result.Rationale = undeterminedRationale(strings.Join(failed, " and "), nil)
+		}
+
+		return checked, false, err
+	}
+
+	return checked, false, nil
+}
+
+// revCheckOCSP checks the certificate using its OCSP servers. It returns done when the outcome has been decided and
+// recorded in the result, otherwise it returns the sources which were checked.
+func revCheckOCSP(ctx context.Context, cert, issuer *x509.Certificate, result *CheckResult) (checked []string, done bool, err error) {
+	if DisableOCSP || len(ocspServers(cert)) == 0 {
+		return nil, false, nil
+	}
+
+	result.Method = MethodOCSP
+
+	if result.Revoked, result.Determined, err = certIsRevokedOCSP(ctx, cert, issuer, HardFail, result); !result.Determined {
+		result.Revoked = HardFail
+		result.Rationale = undeterminedRationale("OCSP", err)
+
+		return nil, true, err
+	} else if result.Revoked {
+		result.Rationale = "revoked per OCSP reason " + ReasonString(result.Reason)
+
+		return nil, true, err
 	}
 
-	if revoked, ok, err = certIsRevokedOCSP(cert, HardFail); !ok {
-		if HardFail {
-			return true, false, err
+	return []string{"OCSP"}, false, nil
+}
+
+// joinErrors joins the errors which aren't nil, returning a lone error as is so it can still be compared directly.
+func joinErrors(errs ...error) error {
+	var joined []error
+
+	for _, err := range errs {
+		if err != nil {
+			joined = append(joined, err)
 		}
+	}
+
+	switch len(joined) {
+	case 0:
+		return nil
+	case 1:
+		return joined[0]
+	default:
+		return errors.Join(joined...)
+	}
+}
 
-		return false, false, err
-	} else if revoked {
-		return true, true, err
+// undeterminedRationale describes a revocation source which could not be checked, and how that affected the outcome.
+func undeterminedRationale(source string, err error) string {
+	rationale := source + " could not be checked"
+
+	if err != nil {
+		rationale += ": " + err.Error()
 	}
 
-	return false, true, nil
+	if HardFail {
+		return rationale + ", treated as revoked in hard-fail mode"
+	}
+
+	return rationale + ", treated as undetermined in fail-open mode"
 }
 
-func getIssuer(cert *x509.Certificate) (issuer *x509.Certificate) {
+func getIssuer(ctx context.Context, cert *x509.Certificate) (issuer *x509.Certificate, err error) {
 	var (
-		uri string
-		err error
+		uri  string
+		errs []error
 	)
 
+	if issuer = poolIssuer(cert); issuer != nil {
+		return issuer, nil
+	}
+
+	if issuerResolver != nil {
+		if issuer, err = issuerResolver(cert); err != nil {
+			if logger != nil {
+				logger.Warn("issuer resolver failed", "serial", cert.SerialNumber, "err", err)
+			}
+
+			return nil, fmt.Errorf("issuer resolver: %w", err)
+		} else if issuer != nil {
+			return issuer, nil
+		}
+	}
+
 	for _, uri = range cert.IssuingCertificateURL {
-		issuer, err = fetchRemote(uri)
+		if CacheIssuers {
+			if issuer = issuerCacheGet(uri); issuer != nil {
+				return issuer, nil
+			}
+		}
+
+		issuer, err = fetchRemote(ctx, uri, cert)
 		if err != nil {
+			if logger != nil {
+				logger.Warn("issuer fetch failed", "url", uri, "err", err)
+			}
+
+			errs = append(errs, fmt.Errorf("issuer at %s: %w", uri, err))
+
 			continue
 		}
-		break
+
+		if CacheIssuers {
+			issuerCachePut(uri, issuer)
+		}
+
+		return issuer, nil
 	}
 
-	return issuer
+	return nil, errors.Join(errs...)
 }
 
 // VerifyCertificate ensures that the certificate passed in hasn't
@@ -89,59 +350,339 @@ func VerifyCertificate(cert *x509.Certificate) (revoked, ok bool) {
 // VerifyCertificateError ensures that the certificate passed in hasn't
 // expired and checks the CRL for the server.
 func VerifyCertificateError(cert *x509.Certificate) (revoked, ok bool, err error) {
-	if !time.Now().Before(cert.NotAfter) {
-		return true, true, fmt.Errorf("Certificate expired %s\n", cert.NotAfter)
-	} else if !time.Now().After(cert.NotBefore) {
-		return true, true, fmt.Errorf("Certificate isn't valid until %s\n", cert.NotBefore)
+	return VerifyCertificateContext(context.Background(), cert)
+}
+
+// VerifyCertificateContext is like VerifyCertificateError but uses the context for every request made while checking
+// the certificate, allowing the check to be cancelled or bounded by a deadline.
+func VerifyCertificateContext(ctx context.Context, cert *x509.Certificate) (revoked, ok bool, err error) {
+	result, err := CheckContext(ctx, cert)
+
+	return result.Revoked, result.Determined, err
+}
+
+// Check ensures that the certificate hasn't expired and checks it for revocation like VerifyCertificateError, but
+// returns a CheckResult describing how the outcome was decided instead of the revoked and ok values. The result is
+// never nil.
+func Check(cert *x509.Certificate) (result *CheckResult, err error) {
+	return CheckContext(context.Background(), cert)
+}
+
+// CheckContext is like Check but uses the context for every request made while checking the certificate.
+func CheckContext(ctx context.Context, cert *x509.Certificate) (result *CheckResult, err error) {
+	return checkCertificate(ctx, cert, nil)
+}
+
+// CheckOCSP checks the certificate using only its OCSP servers and the supplied issuer, without looking up the issuer
+// or checking CRLs, which makes it the lowest latency check when the issuer is already known such as from a verified
+// TLS chain. Cached OCSP responses are used. The certificate's validity period isn't checked. A certificate without
+// any OCSP servers can't be checked and returns ErrNoRevocationInfo.
+func CheckOCSP(ctx context.Context, cert, issuer *x509.Certificate) (result *CheckResult, err error) {
+	result = &CheckResult{Certificate: cert, Method: MethodOCSP, CheckedAt: now()}
+
+	switch {
+	case DisableOCSP:
+		result.Revoked = HardFail
+		result.Rationale = "OCSP checking is disabled"
+
+		return result, ErrRevocationDisabled
+	case len(ocspServers(cert)) == 0:
+		result.Revoked = HardFail
+		result.Rationale = "no OCSP servers"
+
+		return result, ErrNoRevocationInfo
+	}
+
+	if _, done, err := revCheckOCSP(ctx, cert, issuer, result); done {
+		return result, err
 	}
-	return revCheck(cert)
+
+	result.Revoked, result.Determined = false, true
+	result.Rationale = "not revoked per OCSP"
+
+	return result, nil
+}
+
+// VerifyCertificateIssuer is like VerifyCertificateError but uses the supplied issuer instead of fetching it from the
+// URLs in the certificate's Authority Information Access extension. This allows OCSP to be checked, and the CRL
+// signature to be validated, for certificates which don't have an issuer URL but whose issuer is known locally. When
+// issuer is nil it's fetched as usual.
+func VerifyCertificateIssuer(cert, issuer *x509.Certificate) (revoked, ok bool, err error) {
+	return VerifyCertificateIssuerContext(context.Background(), cert, issuer)
+}
+
+// VerifyCertificateIssuerContext is like VerifyCertificateIssuer but uses the context for every request made while
+// checking the certificate.
+func VerifyCertificateIssuerContext(ctx context.Context, cert, issuer *x509.Certificate) (revoked, ok bool, err error) {
+	result, err := checkCertificate(ctx, cert, issuer)
+
+	return result.Revoked, result.Determined, err
 }
 
-func fetchRemote(url string) (*x509.Certificate, error) {
-	resp, err := HTTPClient.Get(url)
+// CertificateRevocationInfo checks the certificate in the same way as VerifyCertificateError, and returns the reason
+// and time of its revocation along with the source which determined it.
+func CertificateRevocationInfo(cert *x509.Certificate) (info *RevocationInfo, ok bool, err error) {
+	return CertificateRevocationInfoContext(context.Background(), cert)
+}
+
+// CertificateRevocationInfoContext is like CertificateRevocationInfo but uses the context for every request made
+// while checking the certificate.
+func CertificateRevocationInfoContext(ctx context.Context, cert *x509.Certificate) (info *RevocationInfo, ok bool, err error) {
+	result, err := checkCertificate(ctx, cert, nil)
+
+	return result.RevocationInfo(), result.Determined, err
+}
+
+// checkCertificate ensures that the certificate passed in hasn't expired and checks its revocation status, using
+// issuer as the issuer of the certificate when it's not nil.
+func checkCertificate(ctx context.Context, cert, issuer *x509.Certificate) (result *CheckResult, err error) {
+	if result, err = checkValidity(cert); result != nil {
+		return result, err
+	}
+
+	result, err = revCheck(ctx, cert, issuer)
+
+	return expiryGrace(result), err
+}
+
+// expiryGrace flags the result of checking a certificate which expired within ExpiryGrace.
+func expiryGrace(result *CheckResult) *CheckResult {
+	if cert := result.Certificate; cert != nil && !now().Before(cert.NotAfter) {
+		result.Expired = true
+		result.Rationale += fmt.Sprintf(", though the certificate expired at %s within the grace period", cert.NotAfter)
+	}
+
+	return result
+}
+
+// checkValidity returns a result when the certificate is outside its validity period, extended by ExpiryGrace, and nil
+// otherwise.
+func checkValidity(cert *x509.Certificate) (result *CheckResult, err error) {
+	if !now().Before(cert.NotAfter.Add(ExpiryGrace)) {
+		return &CheckResult{
+			Certificate: cert, Revoked: true, Determined: true, Method: MethodValidity, CheckedAt: now(),
+			Rationale: fmt.Sprintf("certificate expired at %s", cert.NotAfter),
+		}, fmt.Errorf("%w: %s", ErrCertExpired, cert.NotAfter)
+	} else if !now().After(cert.NotBefore) {
+		return &CheckResult{
+			Certificate: cert, Revoked: true, Determined: true, Method: MethodValidity, CheckedAt: now(),
+			Rationale: fmt.Sprintf("certificate isn't valid until %s", cert.NotBefore),
+		}, fmt.Errorf("%w, valid from %s", ErrCertNotYetValid, cert.NotBefore)
+	}
+
+	return nil, nil
+}
+
+// fetchRemote fetches the issuer of the cert from an Authority Information Access URL, which may serve a DER or PEM
+// encoded certificate or a PKCS #7 bundle of certificates.
+func fetchRemote(ctx context.Context, url string, cert *x509.Certificate) (*x509.Certificate, error) {
+	ctx, cancel := withFetchTimeout(ctx)
+	defer cancel()
+
+	resp, err := httpGet(ctx, rewriteURL(URLKindIssuer, url))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	in, err := remoteRead(limitResponse(resp.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := parseIssuerCertificates(in)
 	if err != nil {
 		return nil, err
 	}
+
+	return selectIssuer(cert, certs)
+}
+
+// fetchCRLBody fetches the body of a CRL and returns it with the response header, which is nil for an LDAP URL. When
+// conditional is true the request uses the validators of the cached CRL so errCRLNotModified is returned if it hasn't
+// changed.
+func fetchCRLBody(ctx context.Context, url string, conditional bool) (body []byte, header http.Header, err error) {
+	ctx, cancel := withFetchTimeout(ctx)
+	defer cancel()
+
+	if ldapURL(url) {
+		body, err = fetchLDAPCRL(ctx, url)
+
+		return body, nil, err
+	}
+
+	reqHeader := http.Header{}
+	reqHeader.Set("Accept-Encoding", "gzip, deflate")
+
+	if conditional {
+		crlLock.RLock()
+		if meta, ok := crlStates[url]; ok {
+			if meta.etag != "" {
+				reqHeader.Set("If-None-Match", meta.etag)
+			}
+
+			if meta.lastModified != "" {
+				reqHeader.Set("If-Modified-Since", meta.lastModified)
+			}
+		}
+		crlLock.RUnlock()
+	}
+
+	resp, err := httpGetHeader(ctx, rewriteURL(URLKindCRL, url), reqHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	defer resp.Body.Close()
 
-	in, err := remoteRead(resp.Body)
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return nil, nil, errCRLNotModified
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, nil, ErrFailedGetCRL
+	}
+
+	decoded, err := decodeResponse(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer decoded.Close()
+
+	if body, err = crlRead(limitResponse(decoded)); err != nil {
+		return nil, nil, err
+	}
+
+	return body, resp.Header, nil
+}
+
+// fetchLDAPCRL fetches the body of a CRL from an LDAP URL using the function set with SetLDAPFetcher, applying the
+// same restrictions as an HTTP fetch.
+func fetchLDAPCRL(ctx context.Context, uri string) ([]byte, error) {
+	if ldapFetcher == nil {
+		return nil, fmt.Errorf("%w: no LDAP fetcher for %s", ErrFailedGetCRL, uri)
+	}
+
+	uri = rewriteURL(URLKindCRL, uri)
+
+	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, err
 	}
 
-	p, _ := pem.Decode(in)
-	if p != nil {
-		return ParseCertificatePEM(in)
+	if OfflineMode {
+		return nil, fmt.Errorf("%w: %s", ErrOfflineMode, u)
 	}
 
-	return x509.ParseCertificate(in)
+	if err = checkHostPolicy(u); err != nil {
+		return nil, err
+	}
+
+	body, err := ldapFetcher(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	if MaxResponseSize > 0 && int64(len(body)) > MaxResponseSize {
+		return nil, ErrResponseTooLarge
+	}
+
+	return body, nil
 }
 
-func certIsRevokedOCSP(leaf *x509.Certificate, strict bool) (revoked, ok bool, e error) {
+// certIsRevokedOCSP checks a cert using its OCSP servers. Returns the same bool pair as revCheck, plus an
+// error if one occurred. The revocation reason and time are recorded in the result when the cert is revoked.
+func certIsRevokedOCSP(ctx context.Context, leaf, issuer *x509.Certificate, strict bool, result *CheckResult) (revoked, ok bool, e error) {
 	var err error
 
-	ocspURLs := leaf.OCSPServer
+	ocspURLs := ocspServers(leaf)
 	if len(ocspURLs) == 0 {
 		// OCSP not enabled for this certificate.
 		return false, true, nil
 	}
 
-	issuer := getIssuer(leaf)
-
 	if issuer == nil {
-		return false, false, nil
+		return false, false, ErrIssuerUnavailable
+	}
+
+	if CacheOCSP {
+		resp := ocspCacheGet(leaf, issuer)
+
+		cacheLookup(URLKindOCSP, resp != nil)
+
+		if resp != nil {
+			if logger != nil {
+				logger.Debug("OCSP cache hit", "serial", leaf.SerialNumber)
+			}
+
+			result.noteCache(true)
+
+			result.ArchiveCutoff = ocspArchiveCutoff(resp)
+
+			if resp.Status != ocsp.Good {
+				result.Reason, result.RevokedAt = resp.RevocationReason, resp.RevokedAt
+			}
+
+			return resp.Status != ocsp.Good, true, nil
+		}
 	}
 
-	ocspRequest, err := ocsp.CreateRequest(leaf, issuer, &ocspOpts)
+	ocspRequest, nonce, err := createOCSPRequest(leaf, issuer, ocspOpts.Hash)
 	if err != nil {
 		return revoked, ok, err
 	}
 
+	// The error from each server is kept so a check which fails against every server reports all of them.
+	var errs []error
+
 	for _, server := range ocspURLs {
-		resp, err := sendOCSPRequest(server, ocspRequest, leaf, issuer)
+		reqNonce := nonce
+		start := time.Now()
+
+		result.noteCache(false)
+
+		resp, err := sendOCSPRequest(ctx, server, ocspRequest, leaf, issuer)
+		if err != nil && OCSPHashFallback && ocspOpts.Hash != crypto.SHA1 {
+			if fallback, fallbackNonce, ferr := createOCSPRequest(leaf, issuer, crypto.SHA1); ferr == nil {
+				if resp, ferr = sendOCSPRequest(ctx, server, fallback, leaf, issuer); ferr != nil {
+					err = errors.Join(err, ferr)
+				} else {
+					err = nil
+				}
+
+				reqNonce = fallbackNonce
+			}
+		}
+
+		if err == nil {
+			err = checkOCSPNonce(resp, reqNonce)
+		}
+
+		if err == nil {
+			err = checkOCSPFresh(resp)
+		}
+
 		if err != nil {
+			hookOCSPFetch(server, start, -1)
+		} else {
+			hookOCSPFetch(server, start, resp.Status)
+		}
+
+		// An unknown status means the responder doesn't know about the cert, which doesn't mean it's revoked.
+		if err == nil && resp.Status == ocsp.Unknown {
+			err = ErrOCSPStatusUnknown
+		}
+
+		if err != nil {
+			if logger != nil {
+				logger.Warn("OCSP check failed", "server", server, "serial", leaf.SerialNumber, "err", err)
+			}
+
+			errs = append(errs, fmt.Errorf("OCSP server %s: %w", server, err))
+
 			if strict {
-				return revoked, ok, err
+				return revoked, ok, errors.Join(errs...)
 			}
 			continue
 		}
@@ -149,28 +690,58 @@ func certIsRevokedOCSP(leaf *x509.Certificate, strict bool) (revoked, ok bool, e
 		// There wasn't an error fetching the OCSP status.
 		ok = true
 
+		result.ArchiveCutoff = ocspArchiveCutoff(resp)
+
+		if CacheOCSP {
+			ocspCachePut(leaf, issuer, resp)
+		}
+
 		if resp.Status != ocsp.Good {
 			// The certificate was revoked.
 			revoked = true
+
+			result.Reason, result.RevokedAt = resp.RevocationReason, resp.RevokedAt
 		}
 
-		return revoked, ok, err
+		return revoked, ok, nil
 	}
-	return revoked, ok, err
+	return revoked, ok, errors.Join(errs...)
 }
 
 // sendOCSPRequest attempts to request an OCSP response from the
 // server. The error only indicates a failure to *fetch* the
 // certificate, and *does not* mean the certificate is valid.
-func sendOCSPRequest(server string, req []byte, leaf, issuer *x509.Certificate) (r *ocsp.Response, err error) {
-	var resp *http.Response
+func sendOCSPRequest(ctx context.Context, server string, req []byte, leaf, issuer *x509.Certificate) (r *ocsp.Response, err error) {
+	var (
+		resp   *http.Response
+		method string
+		reqURL string
+	)
+
+	ctx, cancel := withFetchTimeout(ctx)
+	defer cancel()
+
+	server = rewriteURL(URLKindOCSP, server)
+
+	if OCSPForcePOST || len(req) > OCSPGETThreshold {
+		method, reqURL = http.MethodPost, server
+	} else {
+		method, reqURL = http.MethodGet, server+"/"+url.QueryEscape(base64.StdEncoding.EncodeToString(req))
+	}
+
+	if ocspObserver != nil {
+		ocspObserver(method, reqURL, req)
+	}
+
+	header := http.Header{}
+	header.Set("Accept", "application/ocsp-response")
+
+	if method == http.MethodPost {
+		header.Set("Content-Type", "application/ocsp-request")
 
-	if len(req) > 256 {
-		buf := bytes.NewBuffer(req)
-		resp, err = HTTPClient.Post(server, "application/ocsp-request", buf)
+		resp, err = httpPost(ctx, reqURL, header, req)
 	} else {
-		reqURL := server + "/" + url.QueryEscape(base64.StdEncoding.EncodeToString(req))
-		resp, err = HTTPClient.Get(reqURL)
+		resp, err = httpGetHeader(ctx, reqURL, header)
 	}
 
 	if err != nil {
@@ -180,61 +751,258 @@ func sendOCSPRequest(server string, req []byte, leaf, issuer *x509.Certificate)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("failed to retrieve OSCP")
+		return nil, ErrFailedGetOCSP
 	}
 
-	body, err := ocspRead(resp.Body)
+	// A missing content type is tolerated as some responders don't send one.
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/ocsp-response" {
+			return nil, fmt.Errorf("%w: unexpected content type %q", ErrFailedGetOCSP, contentType)
+		}
+	}
+
+	body, err := ocspRead(limitResponse(resp.Body))
 	if err != nil {
 		return nil, err
 	}
 
 	switch {
 	case bytes.Equal(body, ocsp.UnauthorizedErrorResponse):
-		return nil, errors.New("OSCP unauthorized")
+		return nil, ErrOCSPUnauthorized
 	case bytes.Equal(body, ocsp.MalformedRequestErrorResponse):
-		return nil, errors.New("OSCP malformed")
+		return nil, ErrOCSPMalformed
 	case bytes.Equal(body, ocsp.InternalErrorErrorResponse):
-		return nil, errors.New("OSCP internal error")
+		return nil, ErrOCSPInternalError
 	case bytes.Equal(body, ocsp.TryLaterErrorResponse):
-		return nil, errors.New("OSCP try later")
+		return nil, ErrOCSPTryLater
 	case bytes.Equal(body, ocsp.SigRequredErrorResponse):
-		return nil, errors.New("OSCP signature required")
+		return nil, ErrOCSPSignatureRequired
+	}
+
+	if r, err = ocsp.ParseResponseForCert(body, leaf, issuer); err != nil {
+		return nil, err
+	}
+
+	if err = checkOCSPResponder(r, issuer); err != nil {
+		return nil, err
+	}
+
+	if err = checkOCSPResponderRevoked(ctx, r, issuer); err != nil {
+		return nil, err
 	}
 
-	return ocsp.ParseResponseForCert(body, leaf, issuer)
+	return r, nil
 }
 
 var (
 	// HTTPClient is an instance of http.Client that will be used for all HTTP requests.
 	HTTPClient = http.DefaultClient
 
+	// MaxRedirects is the maximum number of redirects followed by each request. CRL, OCSP, and issuer URLs are taken
+	// from the contents of untrusted certificates, so redirects are limited to stop them being used to probe other
+	// hosts. Zero or less disables following redirects.
+	MaxRedirects = 3
+
+	// SameHostRedirects determines whether redirects are only followed when they're to the host of the original
+	// request.
+	SameHostRedirects = false
+
+	// UserAgent is the User-Agent header sent with every CRL, OCSP, and issuer certificate request, which identifies
+	// the requests to CAs which block or rate limit unknown clients. An empty value sends the HTTPClient's default.
+	UserAgent = "go-webauthn-revoke"
+
 	// HardFail determines whether the failure to check the revocation
 	// status of a certificate (i.e. due to network failure) causes
 	// verification to fail (a hard failure).
 	HardFail = false
 
+	// FetchTimeout is the maximum duration of each individual CRL, OCSP, or issuer certificate fetch. Each fetch has
+	// its own timeout rather than sharing one across the whole check. Zero means no timeout is applied.
+	FetchTimeout time.Duration
+
+	// CheckTimeout is the maximum duration of the whole revocation check of a certificate, shared by every issuer, CRL,
+	// and OCSP fetch it makes, which bounds the worst case latency when a certificate lists several endpoints. Fetches
+	// which would start after it has passed fail with the context's error. Zero means no overall timeout is applied.
+	CheckTimeout time.Duration
+
+	// ExpiryGrace is how long after it expires a certificate is still checked for revocation rather than rejected as
+	// expired, to allow time for rotation. The Expired field of the result is set for such a certificate.
+	ExpiryGrace time.Duration
+
+	// MaxResponseSize is the maximum size in bytes of a CRL, OCSP response, or issuer certificate. Larger responses
+	// fail with ErrResponseTooLarge, preventing endpoints taken from untrusted certificates from forcing unbounded
+	// allocations. Zero or less means no limit.
+	MaxResponseSize int64 = 32 << 20
+
+	// FetchRetries is the number of times a fetch which failed due to a network error or a server error response is
+	// retried. Client error responses aren't retried, and retries stop once the context is done.
+	FetchRetries = 0
+
+	// FetchRetryBackoff is the delay before the first retry of a failed fetch, which doubles for each further retry.
+	FetchRetryBackoff = 100 * time.Millisecond
+
+	// OfflineMode determines whether fetching is prevented entirely, in which case CRLs must be added to the cache
+	// with AddCRL or AddCRLFromFile, and a fetch which would otherwise be made fails with ErrOfflineMode.
+	OfflineMode = false
+
+	// RequireRevocationInfo determines whether a certificate without any CRL distribution point or OCSP server which
+	// can be checked fails with ErrNoRevocationInfo, rather than being treated as valid.
+	RequireRevocationInfo = false
+
+	// DisableCRL determines whether checking certificates against CRLs is skipped.
+	DisableCRL = false
+
+	// DisableOCSP determines whether checking certificates using OCSP is skipped. When both DisableCRL and DisableOCSP
+	// are true the revocation status can't be determined and ErrRevocationDisabled is returned.
+	DisableOCSP = false
+
+	// PreferOCSP determines whether OCSP is checked before CRLs rather than after. When it's true a good or revoked
+	// OCSP answer is final and the CRLs aren't fetched. In fail-open mode the CRLs are only checked when OCSP is
+	// unavailable or can't decide the outcome, and if they can't decide it either the check fails as it would without
+	// PreferOCSP. In hard-fail mode an OCSP failure leaves the outcome undetermined, as it would without PreferOCSP.
+	PreferOCSP = false
+
+	// DeltaCRL determines whether delta CRLs advertised by the freshest CRL extension of the certificate, or of the
+	// base CRL, are checked in addition to the base CRL. Entries in a delta CRL take precedence over the base CRL.
+	// Delta CRLs are only supported when built with Go 1.19 or later.
+	DeltaCRL = false
+
+	// RequireCRLSignature determines whether a fetched CRL is rejected when its issuer can't be located and its
+	// signature therefore can't be checked. It defaults to false for compatibility, which means a CRL fetched for a
+	// certificate whose issuer is unavailable is trusted without confirming its authenticity.
+	RequireCRLSignature = false
+
+	// OCSPGETThreshold is the size in bytes of the DER encoded request above which an OCSP request is sent using POST
+	// rather than GET. Small requests use GET so responses can be cached by HTTP proxies.
+	OCSPGETThreshold = 256
+
+	// OCSPForcePOST determines whether every OCSP request is sent using POST, for responders which don't support the
+	// GET encoding.
+	OCSPForcePOST = false
+
+	// OCSPHashFallback determines whether an OCSP request using SHA-1 is sent to a responder which failed to respond
+	// to a request using the hash set with SetOCSPHash.
+	OCSPHashFallback = false
+
 	crlRead    = io.ReadAll
 	remoteRead = io.ReadAll
 	ocspRead   = io.ReadAll
 
+	now = time.Now
+
+	ocspObserver func(method, url string, req []byte)
+
+	urlRewriter func(kind, url string) string
+
+	ocspServerOverride func(cert *x509.Certificate) []string
+
+	hostPolicy func(u *url.URL) error
+
+	ldapFetcher func(ctx context.Context, url string) ([]byte, error)
+
 	ocspOpts = ocsp.RequestOptions{
 		Hash: crypto.SHA1,
 	}
 
-	crlLock = new(sync.Mutex)
+	crlLock = new(sync.RWMutex)
 )
 
+// The kinds of URL passed to the function set with SetURLRewriter.
+const (
+	URLKindCRL    = "crl"
+	URLKindOCSP   = "ocsp"
+	URLKindIssuer = "issuer"
+)
+
+// ocspServers returns the OCSP servers for the certificate from the function set with SetOCSPServers, or the servers
+// in the certificate when it isn't set or returns none.
+func ocspServers(cert *x509.Certificate) []string {
+	if ocspServerOverride != nil {
+		if servers := ocspServerOverride(cert); len(servers) != 0 {
+			return servers
+		}
+	}
+
+	return cert.OCSPServer
+}
+
+// rewriteURL applies the URL rewriter if one is set.
+func rewriteURL(kind, url string) string {
+	if urlRewriter == nil {
+		return url
+	}
+
+	return urlRewriter(kind, url)
+}
+
 // SetCRLFetcher sets the function to use to read from the http response body
+// of a CRL fetch.
 func SetCRLFetcher(fn func(io.Reader) ([]byte, error)) {
 	crlRead = fn
 }
 
 // SetRemoteFetcher sets the function to use to read from the http response body
+// of an issuer certificate fetch from an Authority Information Access URL.
 func SetRemoteFetcher(fn func(io.Reader) ([]byte, error)) {
 	remoteRead = fn
 }
 
 // SetOCSPFetcher sets the function to use to read from the http response body
+// of an OCSP request.
 func SetOCSPFetcher(fn func(io.Reader) ([]byte, error)) {
 	ocspRead = fn
 }
+
+// SetOCSPRequestObserver sets a function which receives the DER encoded OCSP request along with the HTTP method and
+// URL used to send it, immediately before it's sent. It's intended for debugging responder specific quirks and must
+// not modify the request bytes. Setting it to nil disables the observer.
+func SetOCSPRequestObserver(fn func(method, url string, req []byte)) {
+	ocspObserver = fn
+}
+
+// SetURLRewriter sets a function which rewrites CRL, OCSP, and issuer URLs taken from a certificate before they're
+// fetched, for example to redirect requests to an internal mirror. The kind is one of URLKindCRL, URLKindOCSP, or
+// URLKindIssuer. Setting it to nil disables rewriting.
+func SetURLRewriter(fn func(kind, url string) string) {
+	urlRewriter = fn
+}
+
+// SetOCSPServers sets a function which returns the OCSP servers to query for a certificate in place of the servers in
+// the certificate, for example a mirror operated by the CA or a responder proxy. The servers are still passed to the
+// function set with SetURLRewriter. When it returns no servers the certificate's servers are used, and setting it to
+// nil restores using them for every certificate.
+func SetOCSPServers(fn func(cert *x509.Certificate) []string) {
+	ocspServerOverride = fn
+}
+
+// SetHostPolicy sets a function which is called with the URL of every request, including redirects, before it's
+// made. CRL, OCSP, and issuer URLs are taken from the contents of untrusted certificates, so the policy can be used to
+// block requests to private addresses or restrict them to known CA hosts. A request it returns an error for fails with
+// an error wrapping ErrHostDenied and the policy's error. Setting it to nil allows every host.
+func SetHostPolicy(fn func(u *url.URL) error) {
+	hostPolicy = fn
+}
+
+// SetLDAPFetcher sets a function which fetches the DER or PEM encoded CRL from an ldap:// CRL distribution point,
+// typically by reading the certificateRevocationList;binary attribute with an LDAP client such as go-ldap. The package
+// has no LDAP client of its own, so LDAP distribution points are skipped unless a fetcher is set. Setting it to nil
+// restores skipping them.
+func SetLDAPFetcher(fn func(ctx context.Context, url string) ([]byte, error)) {
+	ldapFetcher = fn
+}
+
+// SetOCSPHash sets the hash algorithm used to identify the certificate in OCSP requests. The default is SHA-1, which
+// some responders reject in favor of SHA-256.
+func SetOCSPHash(h crypto.Hash) {
+	ocspOpts.Hash = h
+}
+
+// SetClock sets the function used to get the current time when checking validity periods and cache freshness. It's
+// intended for deterministic tests, and setting it to nil restores the default of time.Now.
+func SetClock(fn func() time.Time) {
+	if fn == nil {
+		fn = time.Now
+	}
+
+	now = fn
+}
@@ -3,85 +3,353 @@
 package revoke
 
 import (
+	"context"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
 	"time"
 )
 
 // CRLSet associates a PKIX certificate list with the URL the CRL is
-// fetched from.
+// fetched from. It's guarded by the package's CRL cache lock, so it
+// must not be accessed directly while checks are running; use AddCRL
+// and CachedCRLs instead.
 var (
 	CRLSet = map[string]*pkix.CertificateList{}
 )
 
-// fetchCRL fetches and parses a CRL.
-func fetchCRL(url string) (*pkix.CertificateList, error) {
-	resp, err := HTTPClient.Get(url)
-	if err != nil {
-		return nil, err
+// CachedCRLs returns a copy of the CRL cache, keyed by the URL each CRL was fetched from.
+func CachedCRLs() map[string]*pkix.CertificateList {
+	crlLock.RLock()
+	defer crlLock.RUnlock()
+
+	crls := make(map[string]*pkix.CertificateList, len(CRLSet))
+
+	for url, crl := range CRLSet {
+		crls[url] = crl
 	}
 
-	defer resp.Body.Close()
+	return crls
+}
+
+// CRLInfo returns a description of the CRL cached for the URI, without
+// fetching it if it isn't cached.
+func CRLInfo(uri string) (*CRLMeta, bool) {
+	crlLock.RLock()
+	crl, ok := CRLSet[uri]
+	crlLock.RUnlock()
 
-	if resp.StatusCode >= 300 {
-		return nil, ErrFailedGetCRL
+	if !ok || crl == nil {
+		return nil, false
 	}
 
-	body, err := crlRead(resp.Body)
+	return &CRLMeta{
+		ThisUpdate: crl.TBSCertList.ThisUpdate,
+		NextUpdate: crl.TBSCertList.NextUpdate,
+		Number:     crlNumber(crl),
+		EntryCount: len(crl.TBSCertList.RevokedCertificates),
+	}, true
+}
+
+// fetchCRL fetches and parses a CRL, returning it with the response
+// header. When conditional is true the request is conditional on the
+// CRL having changed since it was cached.
+func fetchCRL(ctx context.Context, url string, conditional bool) (crl *pkix.CertificateList, header http.Header, err error) {
+	body, header, err := fetchCRLBody(ctx, url, conditional)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return x509.ParseCRL(body)
-}
+	if crl, err = parseCRL(body); err != nil {
+		return nil, nil, err
+	}
 
-// check a cert against a specific CRL. Returns the same bool pair
-// as revCheck, plus an error if one occurred.
-func certIsRevokedCRL(cert *x509.Certificate, url string) (revoked, ok bool, err error) {
-	var crl *pkix.CertificateList
+	return crl, header, nil
+}
 
-	crlLock.Lock()
+// parseCRL parses a DER or PEM encoded CRL.
+func parseCRL(body []byte) (*pkix.CertificateList, error) {
+	return x509.ParseCRL(crlDER(body))
+}
 
-	if crl, ok = CRLSet[url]; ok && crl == nil {
-		ok = false
+// crlIssuer returns the issuer name of the CRL.
+func crlIssuer(crl *pkix.CertificateList) []byte {
+	var tbs struct {
+		Version   int `asn1:"optional,default:0"`
+		Signature pkix.AlgorithmIdentifier
+		Issuer    asn1.RawValue
+	}
 
-		delete(CRLSet, url)
+	// The parsed issuer drops the encoding of its attributes, so read it from the raw certificate list when there is
+	// one, such as for a parsed CRL.
+	if _, err := asn1.Unmarshal(crl.TBSCertList.Raw, &tbs); err == nil {
+		return tbs.Issuer.FullBytes
 	}
 
+	raw, _ := asn1.Marshal(crl.TBSCertList.Issuer)
+
+	return raw
+}
+
+// AddCRL adds the CRL to the cache as the CRL for the URI, replacing any
+// CRL already cached for it. It's used to seed the cache with CRLs which
+// are distributed out of band.
+func AddCRL(uri string, crl *pkix.CertificateList) {
+	crlLock.Lock()
+	CRLSet[uri] = crl
+	crlStored(uri)
+	delete(crlStates, uri)
 	crlLock.Unlock()
+}
 
-	var shouldFetchCRL = true
+// AddCRLFromFile parses the CRL in the file at path and adds it to the
+// cache as the CRL for the URI.
+func AddCRLFromFile(uri, path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
 
-	if ok && !crl.HasExpired(time.Now()) {
-		shouldFetchCRL = false
+	crl, err := parseCRL(body)
+	if err != nil {
+		return err
 	}
 
-	issuer := getIssuer(cert)
+	AddCRL(uri, crl)
 
-	if shouldFetchCRL {
-		if crl, err = fetchCRL(url); err != nil {
-			return false, false, err
-		}
+	return nil
+}
 
-		// Check the CRL signature.
-		if issuer != nil {
-			if err = issuer.CheckCRLSignature(crl); err != nil {
-				return false, false, err
-			}
+// IsRevokedByCRL returns true if the CRL has an entry for the cert's serial
+// number. It doesn't use the cache, fetch anything, or check the CRL's
+// issuer or signature, which is left to the caller.
+func IsRevokedByCRL(cert *x509.Certificate, crl *pkix.CertificateList) (revoked bool) {
+	for _, rc := range crl.TBSCertList.RevokedCertificates {
+		if cert.SerialNumber.Cmp(rc.SerialNumber) == 0 {
+			return true
 		}
+	}
 
-		crlLock.Lock()
-		CRLSet[url] = crl
-		crlLock.Unlock()
+	return false
+}
+
+// check a cert against a specific CRL. Returns the same bool pair
+// as revCheck, plus an error if one occurred. The revocation reason
+// and time are recorded in the result when the cert is revoked.
+func certIsRevokedCRL(ctx context.Context, cert, issuer *x509.Certificate, url string, result *CheckResult) (revoked, ok bool, err error) {
+	var crl *pkix.CertificateList
+
+	var hit bool
+
+	if crl, hit, err = getCRL(ctx, issuer, url); err != nil {
+		return false, false, err
+	}
+
+	result.noteCache(hit)
+
+	if crl.TBSCertList.Issuer.String() != cert.Issuer.ToRDNSequence().String() {
+		return false, false, fmt.Errorf("%w: %s", ErrCRLIssuerMismatch, url)
 	}
 
 	var rc pkix.RevokedCertificate
 
 	for _, rc = range crl.TBSCertList.RevokedCertificates {
 		if cert.SerialNumber.Cmp(rc.SerialNumber) == 0 {
+			result.Reason, result.RevokedAt = crlEntryReason(rc), rc.RevocationTime
+
 			return true, true, err
 		}
 	}
 
 	return false, true, err
 }
+
+// getCRL returns the CRL for the URL from the cache, or fetches it
+// and checks its signature when it's missing or has expired. The hit
+// value is true when the CRL was served from the cache.
+func getCRL(ctx context.Context, issuer *x509.Certificate, url string) (crl *pkix.CertificateList, hit bool, err error) {
+	var (
+		cached *pkix.CertificateList
+		ok     bool
+		fresh  bool
+	)
+
+	crlLock.RLock()
+	cached, ok = CRLSet[url]
+	crlTouch(url)
+
+	// A nil entry is treated as a cache miss and is replaced once the CRL is fetched.
+	if ok && cached != nil {
+		fresh = crlFresh(url, cached.TBSCertList.ThisUpdate, cached.TBSCertList.NextUpdate)
+	}
+	crlLock.RUnlock()
+
+	if fresh {
+		cacheLookup(URLKindCRL, true)
+
+		if logger != nil {
+			logger.Debug("CRL cache hit", "url", url)
+		}
+
+		return cached, true, nil
+	}
+
+	cacheLookup(URLKindCRL, false)
+
+	if logger != nil {
+		logger.Debug("CRL cache miss", "url", url)
+	}
+
+	// A CRL loaded with LoadCRLBundle has no distribution point to fetch it from again.
+	if isBundleCRLKey(url) {
+		return nil, false, fmt.Errorf("%w: %s", ErrBundleCRLExpired, url)
+	}
+
+	v, err := fetchCRLShared(ctx, url, issuer, func(ctx context.Context) (any, error) {
+		return fetchVerifiedCRL(ctx, issuer, url, cached)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return v.(*pkix.CertificateList), false, nil
+}
+
+// fetchVerifiedCRL fetches the CRL for the URL, checks its signature and
+// that it's no older than the cached CRL it replaces, and caches it.
+func fetchVerifiedCRL(ctx context.Context, issuer *x509.Certificate, url string, cached *pkix.CertificateList) (crl *pkix.CertificateList, err error) {
+	start := time.Now()
+
+	crl, header, err := fetchCRL(ctx, url, cached != nil)
+	if errors.Is(err, errCRLNotModified) {
+		hookCRLFetch(url, start, nil)
+
+		crlRevalidated(url, cached.TBSCertList.ThisUpdate, cached.TBSCertList.NextUpdate)
+
+		return cached, nil
+	}
+
+	hookCRLFetch(url, start, err)
+
+	if err != nil {
+		if logger != nil {
+			logger.Warn("CRL fetch failed", "url", url, "err", err)
+		}
+
+		return nil, err
+	}
+
+	// Check the CRL signature.
+	if issuer == nil && RequireCRLSignature {
+		return nil, fmt.Errorf("%w: can't check the signature of the CRL at %s", ErrIssuerUnavailable, url)
+	}
+
+	if issuer != nil {
+		if err = issuer.CheckCRLSignature(crl); err != nil {
+			if logger != nil {
+				logger.Error("CRL signature verification failed", "url", url, "err", err)
+			}
+
+			return nil, err
+		}
+	}
+
+	// Reject a CRL which is older than the one it replaces.
+	if cached != nil {
+		if number, previous := crlNumber(crl), crlNumber(cached); number != nil && previous != nil && number.Cmp(previous) < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrCRLNumberRegressed, url)
+		}
+	}
+
+	crlLock.Lock()
+	defer crlLock.Unlock()
+
+	// Keep a later CRL stored while this one was being fetched, such as one added with AddCRL.
+	if current := CRLSet[url]; current != nil && current != cached && current.TBSCertList.ThisUpdate.After(crl.TBSCertList.ThisUpdate) {
+		return current, nil
+	}
+
+	CRLSet[url] = crl
+	crlStored(url)
+	crlLoaded(url, issuer)
+	crlValidated(url, header)
+
+	return crl, nil
+}
+
+// refreshCRLs refetches the fetched CRLs which are due to be refreshed by
+// the background refresher running every interval.
+func refreshCRLs(ctx context.Context, interval time.Duration) {
+	type due struct {
+		issuer *x509.Certificate
+		cached *pkix.CertificateList
+	}
+
+	refresh := map[string]due{}
+
+	crlLock.RLock()
+	for url, crl := range CRLSet {
+		if crl == nil {
+			continue
+		}
+
+		if issuer, ok := crlDue(url, crl.TBSCertList.ThisUpdate, crl.TBSCertList.NextUpdate, interval); ok {
+			refresh[url] = due{issuer: issuer, cached: crl}
+		}
+	}
+	crlLock.RUnlock()
+
+	for url, d := range refresh {
+		if ctx.Err() != nil {
+			return
+		}
+
+		_, _ = fetchCRLShared(ctx, url, d.issuer, func(ctx context.Context) (any, error) {
+			return fetchVerifiedCRL(ctx, d.issuer, url, d.cached)
+		})
+	}
+}
+
+// crlNumber returns the CRL number from the CRL's extensions, or nil if it has none.
+func crlNumber(crl *pkix.CertificateList) *big.Int {
+	for _, ext := range crl.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidCRLNumber) {
+			continue
+		}
+
+		var number *big.Int
+
+		if _, err := asn1.Unmarshal(ext.Value, &number); err == nil {
+			return number
+		}
+	}
+
+	return nil
+}
+
+// crlEntryReason returns the reason code from the CRL entry's extensions, or zero if it has none.
+func crlEntryReason(rc pkix.RevokedCertificate) int {
+	for _, ext := range rc.Extensions {
+		if !ext.Id.Equal(oidCRLReason) {
+			continue
+		}
+
+		var reason asn1.Enumerated
+
+		if _, err := asn1.Unmarshal(ext.Value, &reason); err == nil {
+			return int(reason)
+		}
+	}
+
+	return 0
+}
+
+var (
+	oidCRLReason = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+)
@@ -3,82 +3,376 @@
 package revoke
 
 import (
+	"bytes"
+	"context"
 	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
 	"time"
+
+	"golang.org/x/crypto/ocsp"
 )
 
 // CRLSet associates a PKIX certificate list with the URL the CRL is
-// fetched from.
+// fetched from. It's guarded by the package's CRL cache lock, so it
+// must not be accessed directly while checks are running; use AddCRL
+// and CachedCRLs instead.
 var (
 	CRLSet = map[string]*x509.RevocationList{}
 )
 
-// fetchCRL fetches and parses a CRL.
-func fetchCRL(url string) (*x509.RevocationList, error) {
-	resp, err := HTTPClient.Get(url)
+// CachedCRLs returns a copy of the CRL cache, keyed by the URL each CRL was fetched from.
+func CachedCRLs() map[string]*x509.RevocationList {
+	crlLock.RLock()
+	defer crlLock.RUnlock()
+
+	crls := make(map[string]*x509.RevocationList, len(CRLSet))
+
+	for url, crl := range CRLSet {
+		crls[url] = crl
+	}
+
+	return crls
+}
+
+// CRLInfo returns a description of the CRL cached for the URI, without
+// fetching it if it isn't cached.
+func CRLInfo(uri string) (*CRLMeta, bool) {
+	crlLock.RLock()
+	crl, ok := CRLSet[uri]
+	crlLock.RUnlock()
+
+	if !ok || crl == nil {
+		return nil, false
+	}
+
+	return &CRLMeta{
+		ThisUpdate: crl.ThisUpdate,
+		NextUpdate: crl.NextUpdate,
+		Number:     crl.Number,
+		EntryCount: len(crl.RevokedCertificateEntries),
+	}, true
+}
+
+// fetchCRL fetches and parses a CRL, returning it with the response
+// header. When conditional is true the request is conditional on the
+// CRL having changed since it was cached.
+func fetchCRL(ctx context.Context, url string, conditional bool) (crl *x509.RevocationList, header http.Header, err error) {
+	body, header, err := fetchCRLBody(ctx, url, conditional)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	if crl, err = parseCRL(body); err != nil {
+		return nil, nil, err
 	}
 
-	defer resp.Body.Close()
+	return crl, header, nil
+}
+
+// parseCRL parses a DER or PEM encoded CRL.
+func parseCRL(body []byte) (*x509.RevocationList, error) {
+	return x509.ParseRevocationList(crlDER(body))
+}
+
+// crlIssuer returns the issuer name of the CRL.
+func crlIssuer(crl *x509.RevocationList) []byte {
+	return crl.RawIssuer
+}
+
+// AddCRL adds the CRL to the cache as the CRL for the URI, replacing any
+// CRL already cached for it. It's used to seed the cache with CRLs which
+// are distributed out of band.
+func AddCRL(uri string, crl *x509.RevocationList) {
+	crlLock.Lock()
+	CRLSet[uri] = crl
+	crlStored(uri)
+	delete(crlStates, uri)
+	crlLock.Unlock()
+}
 
-	if resp.StatusCode >= 300 {
-		return nil, ErrFailedGetCRL
+// AddCRLFromFile parses the CRL in the file at path and adds it to the
+// cache as the CRL for the URI.
+func AddCRLFromFile(uri, path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
 	}
 
-	body, err := crlRead(resp.Body)
+	crl, err := parseCRL(body)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	AddCRL(uri, crl)
+
+	return nil
+}
+
+// IsRevokedByCRL returns true if the CRL has an entry for the cert's serial
+// number. It doesn't use the cache, fetch anything, or check the CRL's
+// issuer or signature, which is left to the caller.
+func IsRevokedByCRL(cert *x509.Certificate, crl *x509.RevocationList) (revoked bool) {
+	for _, rcert := range crl.RevokedCertificateEntries {
+		if cert.SerialNumber.Cmp(rcert.SerialNumber) == 0 {
+			return true
+		}
 	}
 
-	return x509.ParseRevocationList(body)
+	return false
 }
 
 // check a cert against a specific CRL. Returns the same bool pair
-// as revCheck, plus an error if one occurred.
-func certIsRevokedCRL(cert *x509.Certificate, url string) (revoked, ok bool, err error) {
+// as revCheck, plus an error if one occurred. The revocation reason
+// and time are recorded in the result when the cert is revoked.
+func certIsRevokedCRL(ctx context.Context, cert, issuer *x509.Certificate, url string, result *CheckResult) (revoked, ok bool, err error) {
 	var crl *x509.RevocationList
 
+	var hit bool
+
+	if crl, hit, err = getCRL(ctx, issuer, url); err != nil {
+		return false, false, err
+	}
+
+	result.noteCache(hit)
+
+	if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+		return false, false, fmt.Errorf("%w: %s", ErrCRLIssuerMismatch, url)
+	}
+
+	if DeltaCRL {
+		var found bool
+
+		if revoked, found, err = certIsRevokedDeltaCRL(ctx, cert, issuer, crl, result); err != nil {
+			return false, false, err
+		} else if found {
+			return revoked, true, nil
+		}
+	}
+
+	for _, rcert := range crl.RevokedCertificateEntries {
+		if cert.SerialNumber.Cmp(rcert.SerialNumber) == 0 {
+			result.Reason, result.RevokedAt = rcert.ReasonCode, rcert.RevocationTime
+
+			return true, true, err
+		}
+	}
+
+	return false, true, err
+}
+
+// getCRL returns the CRL for the URL from the cache, or fetches it
+// and checks its signature when it's missing or has expired. The hit
+// value is true when the CRL was served from the cache.
+func getCRL(ctx context.Context, issuer *x509.Certificate, url string) (crl *x509.RevocationList, hit bool, err error) {
+	var (
+		cached *x509.RevocationList
+		ok     bool
+		fresh  bool
+	)
+
+	crlLock.RLock()
+	cached, ok = CRLSet[url]
+	crlTouch(url)
+
+	// A nil entry is treated as a cache miss and is replaced once the CRL is fetched.
+	if ok && cached != nil {
+		fresh = crlFresh(url, cached.ThisUpdate, cached.NextUpdate)
+	}
+	crlLock.RUnlock()
+
+	if fresh {
+		cacheLookup(URLKindCRL, true)
+
+		if logger != nil {
+			logger.Debug("CRL cache hit", "url", url)
+		}
+
+		return cached, true, nil
+	}
+
+	cacheLookup(URLKindCRL, false)
+
+	if logger != nil {
+		logger.Debug("CRL cache miss", "url", url)
+	}
+
+	// A CRL loaded with LoadCRLBundle has no distribution point to fetch it from again.
+	if isBundleCRLKey(url) {
+		return nil, false, fmt.Errorf("%w: %s", ErrBundleCRLExpired, url)
+	}
+
+	v, err := fetchCRLShared(ctx, url, issuer, func(ctx context.Context) (any, error) {
+		return fetchVerifiedCRL(ctx, issuer, url, cached)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return v.(*x509.RevocationList), false, nil
+}
+
+// fetchVerifiedCRL fetches the CRL for the URL, checks its signature and
+// that it's no older than the cached CRL it replaces, and caches it.
+func fetchVerifiedCRL(ctx context.Context, issuer *x509.Certificate, url string, cached *x509.RevocationList) (crl *x509.RevocationList, err error) {
+	start := time.Now()
+
+	crl, header, err := fetchCRL(ctx, url, cached != nil)
+	if errors.Is(err, errCRLNotModified) {
+		hookCRLFetch(url, start, nil)
+
+		crlRevalidated(url, cached.ThisUpdate, cached.NextUpdate)
+
+		return cached, nil
+	}
+
+	hookCRLFetch(url, start, err)
+
+	if err != nil {
+		if logger != nil {
+			logger.Warn("CRL fetch failed", "url", url, "err", err)
+		}
+
+		return nil, err
+	}
+
+	// Check the CRL signature.
+	if issuer == nil && RequireCRLSignature {
+		return nil, fmt.Errorf("%w: can't check the signature of the CRL at %s", ErrIssuerUnavailable, url)
+	}
+
+	if issuer != nil {
+		if err = crl.CheckSignatureFrom(issuer); err != nil {
+			if logger != nil {
+				logger.Error("CRL signature verification failed", "url", url, "err", err)
+			}
+
+			return nil, err
+		}
+	}
+
+	// Reject a CRL which is older than the one it replaces.
+	if cached != nil && cached.Number != nil && crl.Number != nil && crl.Number.Cmp(cached.Number) < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrCRLNumberRegressed, url)
+	}
+
 	crlLock.Lock()
+	defer crlLock.Unlock()
+
+	// Keep a later CRL stored while this one was being fetched, such as one added with AddCRL.
+	if current := CRLSet[url]; current != nil && current != cached && current.ThisUpdate.After(crl.ThisUpdate) {
+		return current, nil
+	}
 
-	if crl, ok = CRLSet[url]; ok && crl == nil {
-		ok = false
+	CRLSet[url] = crl
+	crlStored(url)
+	crlLoaded(url, issuer)
+	crlValidated(url, header)
 
-		delete(CRLSet, url)
+	return crl, nil
+}
+
+// refreshCRLs refetches the fetched CRLs which are due to be refreshed by
+// the background refresher running every interval.
+func refreshCRLs(ctx context.Context, interval time.Duration) {
+	type due struct {
+		issuer *x509.Certificate
+		cached *x509.RevocationList
 	}
 
-	crlLock.Unlock()
+	refresh := map[string]due{}
+
+	crlLock.RLock()
+	for url, crl := range CRLSet {
+		if crl == nil {
+			continue
+		}
+
+		if issuer, ok := crlDue(url, crl.ThisUpdate, crl.NextUpdate, interval); ok {
+			refresh[url] = due{issuer: issuer, cached: crl}
+		}
+	}
+	crlLock.RUnlock()
+
+	for url, d := range refresh {
+		if ctx.Err() != nil {
+			return
+		}
 
-	var shouldFetchCRL = true
+		_, _ = fetchCRLShared(ctx, url, d.issuer, func(ctx context.Context) (any, error) {
+			return fetchVerifiedCRL(ctx, d.issuer, url, d.cached)
+		})
+	}
+}
 
-	if ok && time.Now().Before(crl.NextUpdate) {
-		shouldFetchCRL = false
+// certIsRevokedDeltaCRL checks a cert against the delta CRLs advertised
+// by the freshest CRL extension of the cert or the base CRL. The found
+// value is true when a delta CRL has an entry for the cert, in which
+// case it takes precedence over the base CRL.
+func certIsRevokedDeltaCRL(ctx context.Context, cert, issuer *x509.Certificate, base *x509.RevocationList, result *CheckResult) (revoked, found bool, err error) {
+	urls := freshestCRLURLs(cert.Extensions)
+	if len(urls) == 0 {
+		urls = freshestCRLURLs(base.Extensions)
 	}
 
-	issuer := getIssuer(cert)
+	for _, url := range urls {
+		if skipCRLURL(url) {
+			continue
+		}
+
+		var delta *x509.RevocationList
+
+		var hit bool
 
-	if shouldFetchCRL {
-		if crl, err = fetchCRL(url); err != nil {
+		if delta, hit, err = getCRL(ctx, issuer, url); err != nil {
 			return false, false, err
 		}
 
-		// Check the CRL signature.
-		if issuer != nil {
-			if err = crl.CheckSignatureFrom(issuer); err != nil {
-				return false, false, err
+		result.noteCache(hit)
+
+		if !bytes.Equal(delta.RawIssuer, base.RawIssuer) {
+			return false, false, fmt.Errorf("%w: delta CRL at %s has a different issuer to the base CRL", ErrInvalidDeltaCRL, url)
+		}
+
+		var baseNumber *big.Int
+
+		for _, ext := range delta.Extensions {
+			if ext.Id.Equal(oidDeltaCRLIndicator) {
+				if _, err = asn1.Unmarshal(ext.Value, &baseNumber); err != nil {
+					return false, false, err
+				}
 			}
 		}
 
-		crlLock.Lock()
-		CRLSet[url] = crl
-		crlLock.Unlock()
-	}
+		if baseNumber == nil {
+			return false, false, fmt.Errorf("%w: CRL at %s isn't a delta CRL", ErrInvalidDeltaCRL, url)
+		}
 
-	for _, rcert := range crl.RevokedCertificates {
-		if cert.SerialNumber.Cmp(rcert.SerialNumber) == 0 {
-			return true, true, err
+		if base.Number == nil || base.Number.Cmp(baseNumber) < 0 {
+			return false, false, fmt.Errorf("%w: delta CRL at %s doesn't apply to the base CRL", ErrInvalidDeltaCRL, url)
 		}
+
+		for _, rcert := range delta.RevokedCertificateEntries {
+			if cert.SerialNumber.Cmp(rcert.SerialNumber) != 0 {
+				continue
+			}
+
+			// An entry with the removeFromCRL reason unrevokes a cert held in the base CRL.
+			if rcert.ReasonCode == ocsp.RemoveFromCRL {
+				return false, true, nil
+			}
+
+			result.Reason, result.RevokedAt = rcert.ReasonCode, rcert.RevocationTime
+
+			return true, true, nil
+		}
+
+		return false, false, nil
 	}
 
-	return false, true, err
+	return false, false, nil
 }
@@ -0,0 +1,348 @@
+package revoke
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestPreferOCSPSkipsCRLOnOCSPAnswer(t *testing.T) {
+	resetForTest(t)
+
+	PreferOCSP = true
+	t.Cleanup(func() { PreferOCSP = false })
+
+	ca, caKey := newTestCA(t, "ca")
+
+	var crlRequests atomic.Int32
+
+	crlSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crlRequests.Add(1)
+		_, _ = w.Write(newTestCRL(t, ca, caKey, 1))
+	}))
+	defer crlSrv.Close()
+
+	var resp []byte
+
+	ocspSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+	defer ocspSrv.Close()
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.OCSPServer = []string{ocspSrv.URL}
+		tpl.CRLDistributionPoints = []string{crlSrv.URL}
+	})
+
+	resp = newTestOCSPResponse(t, ca, ca, caKey, 2, ocsp.Response{Status: ocsp.Good})
+
+	result, err := revCheck(context.Background(), leaf, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Revoked || !result.Determined || result.Method != MethodOCSP {
+		t.Fatalf("expected a good OCSP answer, got %+v", result)
+	}
+
+	if n := crlRequests.Load(); n != 0 {
+		t.Fatalf("expected the CRL not to be fetched, got %d requests", n)
+	}
+}
+
+func TestPreferOCSPFallsBackToCRL(t *testing.T) {
+	resetForTest(t)
+
+	PreferOCSP = true
+	t.Cleanup(func() { PreferOCSP = false })
+
+	ca, caKey := newTestCA(t, "ca")
+
+	crlSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(newTestCRL(t, ca, caKey, 1, 2))
+	}))
+	defer crlSrv.Close()
+
+	ocspSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ocspSrv.Close()
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.OCSPServer = []string{ocspSrv.URL}
+		tpl.CRLDistributionPoints = []string{crlSrv.URL}
+	})
+
+	result, err := revCheck(context.Background(), leaf, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Revoked || !result.Determined || result.Method != MethodCRL {
+		t.Fatalf("expected the CRL to decide the outcome, got %+v", result)
+	}
+}
+
+// TestPreferOCSPHardFail checks an OCSP failure in hard-fail mode isn't overruled by a good CRL, whichever is
+// checked first.
+func TestPreferOCSPHardFail(t *testing.T) {
+	resetForTest(t)
+
+	HardFail = true
+	t.Cleanup(func() { HardFail, PreferOCSP = false, false })
+
+	ca, caKey := newTestCA(t, "ca")
+
+	crlSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(newTestCRL(t, ca, caKey, 1))
+	}))
+	defer crlSrv.Close()
+
+	ocspSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ocspSrv.Close()
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.OCSPServer = []string{ocspSrv.URL}
+		tpl.CRLDistributionPoints = []string{crlSrv.URL}
+	})
+
+	for _, prefer := range []bool{false, true} {
+		PreferOCSP = prefer
+
+		result, err := revCheck(context.Background(), leaf, ca)
+		if err == nil {
+			t.Fatalf("expected the OCSP error with PreferOCSP %t", prefer)
+		}
+
+		if !result.Revoked || result.Determined || result.Method != MethodOCSP {
+			t.Fatalf("expected an undetermined OCSP outcome with PreferOCSP %t, got %+v", prefer, result)
+		}
+	}
+}
+
+func TestFailOpenJoinsEveryUncheckedSource(t *testing.T) {
+	resetForTest(t)
+
+	FetchTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { FetchTimeout = 0 })
+
+	ca, caKey := newTestCA(t, "ca")
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	ocspSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ocspSrv.Close()
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.OCSPServer = []string{ocspSrv.URL}
+		tpl.CRLDistributionPoints = []string{missing.URL + "/1.crl", missing.URL + "/2.crl", missing.URL + "/3.crl"}
+	})
+
+	result, err := revCheck(context.Background(), leaf, ca)
+
+	if result.Revoked || result.Determined {
+		t.Fatalf("expected an undetermined result, got %+v", result)
+	}
+
+	if err == nil || strings.Count(err.Error(), ErrFailedGetCRL.Error()) != 3 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the errors of three CRLs and OCSP, got %v", err)
+	}
+}
+
+func TestFailOpenChecksRemainingCRLs(t *testing.T) {
+	resetForTest(t)
+
+	ca, caKey := newTestCA(t, "ca")
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	crlSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(newTestCRL(t, ca, caKey, 1, 2))
+	}))
+	defer crlSrv.Close()
+
+	leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+		tpl.CRLDistributionPoints = []string{missing.URL, crlSrv.URL}
+	})
+
+	result, err := revCheck(context.Background(), leaf, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Revoked || !result.Determined {
+		t.Fatalf("expected the second CRL to find the certificate revoked, got %+v", result)
+	}
+}
+
+func TestURLRewriter(t *testing.T) {
+	testCases := []struct {
+		kind   string
+		mod    func(tpl *x509.Certificate)
+		issuer bool
+	}{
+		{
+			kind:   URLKindCRL,
+			mod:    func(tpl *x509.Certificate) { tpl.CRLDistributionPoints = []string{"http://crl.example.invalid/ca.crl"} },
+			issuer: true,
+		},
+		{
+			kind:   URLKindOCSP,
+			mod:    func(tpl *x509.Certificate) { tpl.OCSPServer = []string{"http://ocsp.example.invalid"} },
+			issuer: true,
+		},
+		{
+			kind: URLKindIssuer,
+			mod: func(tpl *x509.Certificate) {
+				tpl.IssuingCertificateURL = []string{"http://issuer.example.invalid/ca.cer"}
+				tpl.CRLDistributionPoints = []string{"http://crl.example.invalid/ca.crl"}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.kind, func(t *testing.T) {
+			resetForTest(t)
+
+			ca, caKey := newTestCA(t, "ca")
+			crl := newTestCRL(t, ca, caKey, 1)
+			resp := newTestOCSPResponse(t, ca, ca, caKey, 2, ocsp.Response{Status: ocsp.Good})
+
+			var requested atomic.Bool
+
+			mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.URL.Path, "/"+tc.kind) {
+					requested.Store(true)
+				}
+
+				switch {
+				case strings.HasPrefix(r.URL.Path, "/"+URLKindCRL):
+					_, _ = w.Write(crl)
+				case strings.HasPrefix(r.URL.Path, "/"+URLKindOCSP):
+					w.Header().Set("Content-Type", "application/ocsp-response")
+					_, _ = w.Write(resp)
+				case strings.HasPrefix(r.URL.Path, "/"+URLKindIssuer):
+					_, _ = w.Write(ca.Raw)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer mirror.Close()
+
+			SetURLRewriter(func(kind, url string) string {
+				return mirror.URL + "/" + kind
+			})
+			t.Cleanup(func() { SetURLRewriter(nil) })
+
+			leaf := newTestLeaf(t, ca, caKey, 2, tc.mod)
+
+			var issuer *x509.Certificate
+			if tc.issuer {
+				issuer = ca
+			}
+
+			result, err := revCheck(context.Background(), leaf, issuer)
+			if err != nil || !result.Determined {
+				t.Fatalf("expected the check to succeed through the mirror, got %+v, %v", result, err)
+			}
+
+			if !requested.Load() {
+				t.Fatalf("expected the %s URL to be rewritten to the mirror", tc.kind)
+			}
+		})
+	}
+}
+
+func TestRationale(t *testing.T) {
+	testCases := []struct {
+		name      string
+		crl       func(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) http.Handler
+		hardFail  bool
+		rationale string
+	}{
+		{
+			name:      "NoRevocationInfo",
+			rationale: "no revocation information, treated as valid",
+		},
+		{
+			name: "Revoked",
+			crl: func(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) http.Handler {
+				crl := newTestCRL(t, ca, caKey, 1, 2)
+
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(crl) })
+			},
+			rationale: "revoked per CRL at %s reason keyCompromise",
+		},
+		{
+			name: "NotRevoked",
+			crl: func(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) http.Handler {
+				crl := newTestCRL(t, ca, caKey, 1)
+
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(crl) })
+			},
+			rationale: "not revoked per CRL at %s",
+		},
+		{
+			name: "FailOpen",
+			crl: func(*testing.T, *x509.Certificate, *ecdsa.PrivateKey) http.Handler {
+				return http.NotFoundHandler()
+			},
+			rationale: "CRL at %s could not be checked: failed to retrieve CRL, treated as undetermined in fail-open mode",
+		},
+		{
+			name: "HardFail",
+			crl: func(*testing.T, *x509.Certificate, *ecdsa.PrivateKey) http.Handler {
+				return http.NotFoundHandler()
+			},
+			hardFail:  true,
+			rationale: "CRL at %s could not be checked: failed to retrieve CRL, treated as revoked in hard-fail mode",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetForTest(t)
+
+			HardFail = tc.hardFail
+			t.Cleanup(func() { HardFail = false })
+
+			ca, caKey := newTestCA(t, "ca")
+
+			rationale := tc.rationale
+
+			leaf := newTestLeaf(t, ca, caKey, 2, func(tpl *x509.Certificate) {
+				if tc.crl == nil {
+					return
+				}
+
+				srv := httptest.NewServer(tc.crl(t, ca, caKey))
+				t.Cleanup(srv.Close)
+
+				tpl.CRLDistributionPoints = []string{srv.URL}
+				rationale = fmt.Sprintf(tc.rationale, srv.URL)
+			})
+
+			result, _ := revCheck(context.Background(), leaf, ca)
+			if result.Rationale != rationale {
+				t.Fatalf("expected the rationale %q, got %q", rationale, result.Rationale)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package revoke
+
+import "sync/atomic"
+
+// VerifierStats is a snapshot of the effectiveness of the CRL and OCSP caches, returned by Stats.
+type VerifierStats struct {
+	CRL  CacheStats
+	OCSP CacheStats
+}
+
+// CacheStats describes a cache. Hits, Misses, and Evictions count from when the program started and aren't reset by
+// Reset or by clearing the cache, so they can be exported as counters, while Entries is the current size. A CRL is
+// evicted when the cache exceeds MaxCRLCacheEntries, and an OCSP response is evicted when it's found to have expired.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+}
+
+// cacheCounters holds the counters for a cache, updated atomically so they can be read without locking.
+type cacheCounters struct {
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	entries   atomic.Int64
+}
+
+func (c *cacheCounters) snapshot() CacheStats {
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   int(c.entries.Load()),
+	}
+}
+
+var crlStats, ocspStats cacheCounters
+
+// Stats returns the hit, miss, eviction, and entry counts of the CRL and OCSP caches. It doesn't take any locks, so
+// it's cheap enough to call whenever metrics are scraped.
+func Stats() VerifierStats {
+	return VerifierStats{
+		CRL:  crlStats.snapshot(),
+		OCSP: ocspStats.snapshot(),
+	}
+}
+
+// cacheLookup records a lookup in the CRL or OCSP cache and calls the OnCacheLookup hook.
+func cacheLookup(kind string, hit bool) {
+	stats := &crlStats
+	if kind == URLKindOCSP {
+		stats = &ocspStats
+	}
+
+	if hit {
+		stats.hits.Add(1)
+	} else {
+		stats.misses.Add(1)
+	}
+
+	hookCacheLookup(kind, hit)
+}
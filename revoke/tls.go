@@ -0,0 +1,136 @@
+package revoke
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// TLSVerify checks the peer certificate chain of a TLS connection for revocation in a single call. The leaf
+// certificate is checked using the stapled OCSP response when a valid one is present, and each certificate uses the
+// next certificate in the chain as its issuer instead of fetching it. The chain is checked the same way as
+// VerifyChain, so a final self-signed root isn't checked, and the result identifies the certificate and the mechanism
+// which decided the outcome.
+func TLSVerify(state *tls.ConnectionState) (result *CheckResult, err error) {
+	return TLSVerifyContext(context.Background(), state)
+}
+
+// TLSVerifyContext is like TLSVerify but uses the context for every request made while checking the chain. Checks
+// still running when it returns early are cancelled.
+func TLSVerifyContext(ctx context.Context, state *tls.ConnectionState) (result *CheckResult, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chain := state.PeerCertificates
+	if len(state.VerifiedChains) != 0 {
+		chain = state.VerifiedChains[0]
+	}
+
+	if len(chain) == 0 {
+		return nil, ErrNoPeerCertificates
+	}
+
+	return verifyChain(chain, func(i int) (*CheckResult, error) {
+		issuer := chainIssuer(chain, i)
+
+		if i != 0 || issuer == nil || len(state.OCSPResponse) == 0 {
+			return checkCertificate(ctx, chain[i], issuer)
+		}
+
+		if result, err := checkValidity(chain[i]); result != nil {
+			return result, err
+		}
+
+		if result, err := checkStapledOCSP(state.OCSPResponse, chain[i], issuer); err == nil {
+			return expiryGrace(result), nil
+		}
+
+		result, err := revCheck(ctx, chain[i], issuer)
+
+		return expiryGrace(result), err
+	})
+}
+
+// VerifyConnection checks the peer certificate chain of a TLS connection for revocation using TLSVerify, and returns
+// an error wrapping ErrCertRevoked if any certificate in the chain is revoked or, when HardFail is true, if the
+// revocation status couldn't be determined. It can be used directly as the VerifyConnection function of a tls.Config.
+func VerifyConnection(cs tls.ConnectionState) error {
+	result, err := TLSVerify(&cs)
+	if result == nil {
+		return err
+	}
+
+	if !result.Revoked {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrCertRevoked, result.Rationale, err)
+	}
+
+	return fmt.Errorf("%w: %s", ErrCertRevoked, result.Rationale)
+}
+
+// CheckStapledOCSP checks the certificate against a stapled OCSP response, such as the OCSPResponse of a
+// tls.ConnectionState, without making any requests. The response must be for the certificate, must be signed by the
+// issuer or a responder it delegated to, and must be current. It returns the same values as VerifyCertificateError,
+// and a response which doesn't meet these requirements or has an unknown status is reported as an error.
+func CheckStapledOCSP(response []byte, cert, issuer *x509.Certificate) (revoked, ok bool, err error) {
+	result, err := checkStapledOCSP(response, cert, issuer)
+	if err != nil {
+		return false, false, err
+	}
+
+	return result.Revoked, result.Determined, nil
+}
+
+// ParseAndVerifyOCSP checks the certificate against a DER encoded OCSP response obtained elsewhere, such as from a
+// shared responder proxy, without making any requests. The response is checked the same way as a stapled response
+// and its status is interpreted the same way as a fetched one, so a response with an unknown status is reported as
+// ErrOCSPStatusUnknown. The response nonce isn't checked as the request isn't known.
+func ParseAndVerifyOCSP(der []byte, cert, issuer *x509.Certificate) (result *CheckResult, err error) {
+	return verifyOCSPResponse(der, cert, issuer, MethodOCSP, "OCSP response")
+}
+
+// checkStapledOCSP checks the certificate against a stapled OCSP response.
+func checkStapledOCSP(response []byte, cert, issuer *x509.Certificate) (result *CheckResult, err error) {
+	return verifyOCSPResponse(response, cert, issuer, MethodStapledOCSP, "stapled OCSP response")
+}
+
+// verifyOCSPResponse checks the certificate against an OCSP response, recording the method and describing the
+// response as source in the rationale. The response must be signed by the issuer or a responder it delegated to, and
+// must be current. A delegated responder certificate isn't checked for revocation as that would require fetching its
+// CRLs.
+func verifyOCSPResponse(response []byte, cert, issuer *x509.Certificate, method, source string) (result *CheckResult, err error) {
+	resp, err := ocsp.ParseResponseForCert(response, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkOCSPResponder(resp, issuer); err != nil {
+		return nil, err
+	}
+
+	if err = checkOCSPFresh(resp); err != nil {
+		return nil, err
+	}
+
+	result = &CheckResult{Certificate: cert, Method: method, CheckedAt: now(), ArchiveCutoff: ocspArchiveCutoff(resp)}
+
+	switch resp.Status {
+	case ocsp.Good:
+		result.Determined = true
+		result.Rationale = "not revoked per " + source
+	case ocsp.Revoked:
+		result.Revoked, result.Determined = true, true
+		result.Reason, result.RevokedAt = resp.RevocationReason, resp.RevokedAt
+		result.Rationale = "revoked per " + source + " reason " + ReasonString(result.Reason)
+	default:
+		return nil, ErrOCSPStatusUnknown
+	}
+
+	return result, nil
+}